@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupRoot holds one directory per backup set, named by its ID
+var backupRoot = filepath.Join(homeDir, ".macdevtui-backups")
+
+// backupManifest is the file in each backup set that lists its entries
+const backupManifest = "manifest.json"
+
+// BackupSet is the set of files an installation run replaced, moved aside
+// so they can be put back with restore
+type BackupSet struct {
+	ID        string        `json:"id"`
+	CreatedAt time.Time     `json:"created_at"`
+	Entries   []BackupEntry `json:"entries"`
+
+	mu sync.Mutex
+}
+
+// BackupEntry is one file moved into a backup set
+type BackupEntry struct {
+	Original string      `json:"original"` // Absolute path the file was moved from
+	Stored   string      `json:"stored"`   // Path relative to the backup set directory
+	StepID   string      `json:"step"`
+	Mode     os.FileMode `json:"mode"`
+}
+
+// newBackupSet starts an empty backup set. Nothing is written until the
+// first file is added, so runs that overwrite nothing leave no trace.
+func newBackupSet() *BackupSet {
+	return &BackupSet{ID: time.Now().Format("20060102-150405")}
+}
+
+// dir returns the directory the set is stored in
+func (b *BackupSet) dir() string {
+	return filepath.Join(backupRoot, b.ID)
+}
+
+// Add moves the file at path into the backup set. A path that is already
+// in the set is left alone so a retried step keeps the original file.
+func (b *BackupSet) Add(stepID, path string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, entry := range b.Entries {
+		if entry.Original == path {
+			return nil
+		}
+	}
+
+	if len(b.Entries) == 0 {
+		// Two runs started within the same second get distinct sets
+		base := b.ID
+		for i := 2; ; i++ {
+			if _, err := os.Stat(b.dir()); errors.Is(err, os.ErrNotExist) {
+				break
+			}
+			b.ID = fmt.Sprintf("%s-%d", base, i)
+		}
+		b.CreatedAt = time.Now()
+	}
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	stored := filepath.Join("files", strings.TrimPrefix(filepath.Clean(path), string(filepath.Separator)))
+	storedPath := filepath.Join(b.dir(), stored)
+	if err := os.MkdirAll(filepath.Dir(storedPath), 0755); err != nil {
+		return fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	if err := moveFile(path, storedPath, info.Mode()); err != nil {
+		return fmt.Errorf("failed to back up %s: %w", path, err)
+	}
+
+	b.Entries = append(b.Entries, BackupEntry{Original: path, Stored: stored, StepID: stepID, Mode: info.Mode()})
+	logger.Printf("[%s] Backed up %s to %s", stepID, path, storedPath)
+	return b.saveLocked()
+}
+
+// Files returns the original paths of the files in the set
+func (b *BackupSet) Files() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var files []string
+	for _, entry := range b.Entries {
+		files = append(files, entry.Original)
+	}
+	return files
+}
+
+// saveLocked writes the manifest through a temporary file, like checkpoints
+func (b *BackupSet) saveLocked() error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(b.dir(), backupManifest)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Restore copies every file in the set back to where it came from,
+// replacing what is there now. The set itself is kept.
+func (b *BackupSet) Restore() ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var restored []string
+	for _, entry := range b.Entries {
+		src := filepath.Join(b.dir(), entry.Stored)
+		if err := os.MkdirAll(filepath.Dir(entry.Original), 0755); err != nil {
+			return restored, err
+		}
+		// A link installed in symlink mode must go rather than be written
+		// through, which would change the file in the dotfiles repo
+		if info, err := os.Lstat(entry.Original); err == nil && (info.Mode()&os.ModeSymlink != 0 || !entry.Mode.IsRegular()) {
+			if err := os.RemoveAll(entry.Original); err != nil {
+				return restored, fmt.Errorf("failed to restore %s: %w", entry.Original, err)
+			}
+		}
+		if err := copyEntry(src, entry.Original, entry.Mode); err != nil {
+			return restored, fmt.Errorf("failed to restore %s: %w", entry.Original, err)
+		}
+		restored = append(restored, entry.Original)
+	}
+	return restored, nil
+}
+
+// Summary describes the set on a single line
+func (b *BackupSet) Summary() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var steps []string
+	for _, entry := range b.Entries {
+		if !containsString(steps, entry.StepID) {
+			steps = append(steps, entry.StepID)
+		}
+	}
+	return fmt.Sprintf("%s  %s  %d file(s) from %s",
+		b.ID, b.CreatedAt.Format("2006-01-02 15:04"), len(b.Entries), strings.Join(steps, ", "))
+}
+
+// listBackups returns the backup sets on disk, newest first
+func listBackups() ([]*BackupSet, error) {
+	dirs, err := os.ReadDir(backupRoot)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var sets []*BackupSet
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		set, err := loadBackup(dir.Name())
+		if err != nil {
+			logger.Printf("Ignoring backup %s: %v", dir.Name(), err)
+			continue
+		}
+		sets = append(sets, set)
+	}
+	sort.Slice(sets, func(i, j int) bool {
+		return sets[i].CreatedAt.After(sets[j].CreatedAt)
+	})
+	return sets, nil
+}
+
+// loadBackup reads the manifest of the backup set with the given ID
+func loadBackup(id string) (*BackupSet, error) {
+	data, err := os.ReadFile(filepath.Join(backupRoot, id, backupManifest))
+	if err != nil {
+		return nil, err
+	}
+	var set BackupSet
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse backup manifest: %w", err)
+	}
+	set.ID = id
+	return &set, nil
+}
+
+// findBackup returns the backup set with the given ID, or the newest one
+// for "latest"
+func findBackup(id string) (*BackupSet, error) {
+	if id != "latest" {
+		return loadBackup(id)
+	}
+	sets, err := listBackups()
+	if err != nil {
+		return nil, err
+	}
+	if len(sets) == 0 {
+		return nil, fmt.Errorf("no backups found in %s", backupRoot)
+	}
+	return sets[0], nil
+}
+
+// printBackups lists the backup sets on stdout
+func printBackups() error {
+	sets, err := listBackups()
+	if err != nil {
+		return err
+	}
+	if len(sets) == 0 {
+		fmt.Fprintf(os.Stdout, "No backups in %s\n", backupRoot)
+		return nil
+	}
+	fmt.Fprintf(os.Stdout, "Backups in %s (newest first):\n\n", backupRoot)
+	for _, set := range sets {
+		fmt.Fprintln(os.Stdout, set.Summary())
+		for _, file := range set.Files() {
+			fmt.Fprintf(os.Stdout, "    %s\n", file)
+		}
+	}
+	return nil
+}
+
+// restoreBackupCLI restores the backup set with the given ID from the
+// command line
+func restoreBackupCLI(id string) error {
+	set, err := findBackup(id)
+	if err != nil {
+		return err
+	}
+	restored, err := set.Restore()
+	for _, file := range restored {
+		fmt.Fprintf(os.Stdout, "restored %s\n", file)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "Restored %d file(s) from backup %s\n", len(restored), set.ID)
+	return nil
+}
+
+// moveFile renames src to dest, falling back to copy and delete when they
+// are on different filesystems
+func moveFile(src, dest string, mode os.FileMode) error {
+	if err := os.Rename(src, dest); err == nil {
+		return nil
+	}
+	if err := copyEntry(src, dest, mode); err != nil {
+		return err
+	}
+	return os.RemoveAll(src)
+}
+
+// copyEntry copies a file, symlink or directory tree from src to dest
+func copyEntry(src, dest string, mode os.FileMode) error {
+	switch {
+	case mode&os.ModeSymlink != 0:
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(target, dest)
+	case mode.IsDir():
+		if err := os.MkdirAll(dest, mode.Perm()); err != nil {
+			return err
+		}
+		entries, err := os.ReadDir(src)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			if err := copyEntry(filepath.Join(src, entry.Name()), filepath.Join(dest, entry.Name()), info.Mode()); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return copyFileMode(src, dest, mode.Perm())
+	}
+}
+
+// copyFileMode copies src to dest through a temporary file, giving dest
+// the given permissions
+func copyFileMode(src, dest string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	return replaceFile(dest, perm, func(w io.Writer) error {
+		_, err := io.Copy(w, in)
+		return err
+	})
+}
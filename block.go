@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Marker lines around the block macDevTUI manages inside a shell file
+const (
+	blockStart = "# >>> macdevtui >>>"
+	blockEnd   = "# <<< macdevtui <<<"
+	blockNote  = "# Managed by macDevTUI: edits inside this block are replaced on the next run"
+)
+
+// findBlock returns the byte range of the managed block in data, from the
+// start of its first marker line to the end of its last, or ok false when
+// data has no complete block
+func findBlock(data []byte) (start, end int, ok bool) {
+	start = -1
+	offset := 0
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		trimmed := string(bytes.TrimSpace(line))
+		switch {
+		case trimmed == blockStart && start < 0:
+			start = offset
+		case trimmed == blockEnd && start >= 0:
+			return start, offset + len(line), true
+		}
+		offset += len(line)
+	}
+	return 0, 0, false
+}
+
+// setBlock returns data with its managed block holding body: replaced in
+// place when there is one, appended after a blank line otherwise
+func setBlock(data, body []byte) []byte {
+	var block bytes.Buffer
+	block.WriteString(blockStart + "\n" + blockNote + "\n")
+	block.Write(body)
+	if len(body) > 0 && !bytes.HasSuffix(body, []byte("\n")) {
+		block.WriteByte('\n')
+	}
+	block.WriteString(blockEnd + "\n")
+
+	if start, end, ok := findBlock(data); ok {
+		return append(append(append([]byte{}, data[:start]...), block.Bytes()...), data[end:]...)
+	}
+	out := append([]byte{}, data...)
+	if len(out) > 0 {
+		if !bytes.HasSuffix(out, []byte("\n")) {
+			out = append(out, '\n')
+		}
+		out = append(out, '\n')
+	}
+	return append(out, block.Bytes()...)
+}
+
+// removeBlock returns data without its managed block and the blank line
+// setBlock put before it, reporting whether there was one
+func removeBlock(data []byte) ([]byte, bool) {
+	start, end, ok := findBlock(data)
+	if !ok {
+		return data, false
+	}
+	before := data[:start]
+	if bytes.HasSuffix(before, []byte("\n\n")) {
+		before = before[:len(before)-1]
+	}
+	return append(append([]byte{}, before...), data[end:]...), true
+}
+
+// writeBlock installs src as the managed block inside dest, leaving the
+// rest of dest alone. Templates are rendered first. A missing dest is
+// created holding just the block.
+func (r *stepRun) writeBlock(src, dest string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file and cannot be managed as a block", src)
+	}
+	dest = templateDest(src, dest)
+	body, err := sourceContent(src, r.config)
+	if err != nil {
+		return err
+	}
+
+	current, err := os.ReadFile(dest)
+	exists := err == nil
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	updated := setBlock(current, body)
+
+	change := FileCreated
+	note := "new file"
+	switch {
+	case !exists:
+	case bytes.Equal(updated, current):
+		change, note = FileUnchanged, "block unchanged"
+	case hasBlock(current):
+		change, note = FileUpdated, "block updated in place"
+	default:
+		change, note = FileUpdated, "block appended"
+	}
+
+	if r.dryRun {
+		r.record(PlannedAction{Kind: ActionBlock, Source: src, Dest: dest, Overwrite: change == FileUpdated, Unchanged: change == FileUnchanged, Note: note})
+		return nil
+	}
+	if err := r.ctx.Err(); err != nil {
+		return err
+	}
+	if r.inst.keepFiles[dest] {
+		r.output("# kept current file, skipped in review: " + dest)
+		r.advance(fmt.Sprintf("Kept %s", filepath.Base(dest)))
+		return nil
+	}
+	if change == FileUnchanged {
+		r.wrote(dest, change, fmt.Sprintf("%s unchanged", filepath.Base(dest)))
+		return nil
+	}
+
+	mode := os.FileMode(0644)
+	if exists {
+		destInfo, err := os.Stat(dest)
+		if err != nil {
+			return err
+		}
+		mode = destInfo.Mode() & preservedModeBits
+		// The backup keeps the whole file as it was before the block changed
+		if r.inst.backup != nil {
+			if err := r.inst.backup.Add(r.step.ID(), dest); err != nil {
+				return err
+			}
+			r.output("# backed up " + dest)
+		}
+	}
+	if err := writeFile(dest, updated, mode); err != nil {
+		return err
+	}
+	r.wrote(dest, change, fmt.Sprintf("Updated block in %s", filepath.Base(dest)))
+	return nil
+}
+
+// hasBlock reports whether data contains a managed block
+func hasBlock(data []byte) bool {
+	_, _, ok := findBlock(data)
+	return ok
+}
+
+// blockFiles returns the home paths of the shell files managed as blocks
+func blockFiles(config *InstallConfig) []string {
+	var paths []string
+	for _, file := range config.Shell.ShellFiles {
+		if file.ManageMode(config.Shell.Manage) == ManageBlock {
+			paths = append(paths, filepath.Join(homeDir, templateDest(file.Path, file.Path)))
+		}
+	}
+	return paths
+}
+
+// runUninstall removes the managed blocks from the shell files, leaving
+// everything else in them as it is. With dryRun it only lists them.
+func runUninstall(dryRun bool) error {
+	config, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+
+	paths := blockFiles(config)
+	if len(paths) == 0 {
+		fmt.Fprintln(os.Stdout, "No shell files are managed as blocks")
+		return nil
+	}
+	removed := 0
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		stripped, ok := removeBlock(data)
+		if !ok {
+			fmt.Fprintf(os.Stdout, "%s has no managed block\n", path)
+			continue
+		}
+		if dryRun {
+			fmt.Fprintf(os.Stdout, "would remove the managed block from %s\n", path)
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if err := writeFile(path, stripped, info.Mode()&preservedModeBits); err != nil {
+			return fmt.Errorf("failed to remove the managed block from %s: %w", path, err)
+		}
+		logger.Printf("Removed managed block from %s", path)
+		fmt.Fprintf(os.Stdout, "removed the managed block from %s\n", path)
+		removed++
+	}
+	if !dryRun {
+		fmt.Fprintf(os.Stdout, "Removed %d managed block(s)\n", removed)
+	}
+	return nil
+}
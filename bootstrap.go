@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// defaultInstallerURL is the official Homebrew install script
+const defaultInstallerURL = "https://raw.githubusercontent.com/Homebrew/install/HEAD/install.sh"
+
+// defaultNixInstallerURL is the official Nix install script
+const defaultNixInstallerURL = "https://nixos.org/nix/install"
+
+// maxInstallerSize bounds install script downloads
+const maxInstallerSize = 4 << 20
+
+// brewLocations are where the Homebrew installer puts brew: Apple Silicon,
+// Intel Macs and Linux
+var brewLocations = []string{
+	"/opt/homebrew/bin/brew",
+	"/usr/local/bin/brew",
+	"/home/linuxbrew/.linuxbrew/bin/brew",
+}
+
+// shellenvVars are the variables taken over from brew shellenv
+var shellenvVars = []string{"PATH", "MANPATH", "INFOPATH"}
+
+// bootstrapHomebrew installs Homebrew when it is missing, with the install
+// script from the homebrew.installer_ settings run with NONINTERACTIVE=1.
+// Afterwards the environment is updated from brew shellenv so later steps
+// find brew.
+func bootstrapHomebrew(run *stepRun) error {
+	if _, err := exec.LookPath("brew"); err == nil {
+		return nil
+	}
+	// An installed brew that is not on PATH yet only needs its environment
+	if brew := findBrew(); brew != "" {
+		return loadShellenv(run, brew)
+	}
+
+	installer := installScript{
+		name:       "Homebrew",
+		setting:    "homebrew.installer_sha256",
+		defaultURL: defaultInstallerURL,
+		config:     run.config.Homebrew.Installer(),
+		shell:      []string{"env", "NONINTERACTIVE=1", "/bin/bash"},
+	}
+	if err := installer.run(run); err != nil || run.dryRun {
+		return err
+	}
+	brew := findBrew()
+	if brew == "" {
+		return fmt.Errorf("Homebrew installer finished but brew was not found in %s", strings.Join(brewLocations, ", "))
+	}
+	return loadShellenv(run, brew)
+}
+
+// installScript installs a package manager with its install script
+type installScript struct {
+	name       string          // Package manager, for messages
+	setting    string          // Config key of the checksum, for messages
+	defaultURL string          // Official install script
+	config     InstallerConfig // Where the script comes from and what it must hash to
+	shell      []string        // Runs the script, whose path follows
+	args       []string        // Arguments for the script
+}
+
+// source names where the install script comes from
+func (s installScript) source() string {
+	if s.config.File != "" {
+		return s.config.File
+	}
+	if s.config.URL != "" {
+		return s.config.URL
+	}
+	return s.defaultURL
+}
+
+// run gets the install script from the configured file or downloads it,
+// checks it against the configured checksum and runs it. A download
+// without a checksum only runs when the checksum is "skip"; a local file
+// is trusted.
+func (s installScript) run(run *stepRun) error {
+	source := s.source()
+	if run.dryRun {
+		note := fmt.Sprintf("install %s with %s", s.name, source)
+		switch s.config.SHA256 {
+		case "":
+			if s.config.File == "" {
+				return fmt.Errorf("%s installer %s is not pinned; set %s to verify it, or to %q to run it unverified", s.name, source, s.setting, InstallerUnpinned)
+			}
+		case InstallerUnpinned:
+			note += ", unverified"
+		default:
+			note += ", verified against sha256 " + s.config.SHA256
+		}
+		run.record(PlannedAction{Kind: ActionNote, Note: note})
+		return run.runCommand(Command{Args: s.command("<install.sh>")})
+	}
+
+	script, err := s.read(run)
+	if err != nil {
+		return fmt.Errorf("failed to get the %s installer: %w", s.name, err)
+	}
+	sum := sha256.Sum256(script)
+	digest := hex.EncodeToString(sum[:])
+	switch {
+	case s.config.SHA256 == "" && s.config.File != "":
+		run.output(fmt.Sprintf("# using local %s installer %s with sha256 %s", s.name, source, digest))
+	case s.config.SHA256 == "":
+		return fmt.Errorf("%s installer %s is not pinned; set %s to %s to verify it, or to %q to run it unverified", s.name, source, s.setting, digest, InstallerUnpinned)
+	case s.config.SHA256 == InstallerUnpinned:
+		run.warn(fmt.Sprintf("%s installer %s runs unverified; set %s to %s to pin it", s.name, source, s.setting, digest))
+	case !strings.EqualFold(digest, s.config.SHA256):
+		return fmt.Errorf("%s installer %s has sha256 %s, expected %s; refusing to run it", s.name, source, digest, s.config.SHA256)
+	default:
+		run.output(fmt.Sprintf("# verified %s installer sha256 %s", s.name, digest))
+	}
+
+	tmp, err := os.CreateTemp("", "macdevtui-install-*.sh")
+	if err != nil {
+		return fmt.Errorf("failed to save the %s installer: %w", s.name, err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(script)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save the %s installer: %w", s.name, err)
+	}
+
+	// The script's temporary path differs on every run, so a resumed run
+	// recognises the command by the script's digest instead
+	cmd := Command{Args: s.command(tmp.Name()), Key: commandKey(s.command("sha256:" + digest))}
+	if err := run.runCommand(cmd); err != nil {
+		return fmt.Errorf("failed to install %s: %w", s.name, err)
+	}
+	return nil
+}
+
+// command runs the script at path
+func (s installScript) command(path string) []string {
+	args := append(append([]string{}, s.shell...), path)
+	return append(args, s.args...)
+}
+
+// read returns the install script: the local file when one is configured,
+// otherwise a download of the installer URL
+func (s installScript) read(run *stepRun) ([]byte, error) {
+	if s.config.File != "" {
+		return os.ReadFile(expandPath(s.config.File))
+	}
+
+	url := s.source()
+	run.output("# downloading " + url)
+	req, err := http.NewRequestWithContext(run.ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	script, err := io.ReadAll(io.LimitReader(resp.Body, maxInstallerSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(script) > maxInstallerSize {
+		return nil, fmt.Errorf("%s is larger than %d bytes", url, maxInstallerSize)
+	}
+	return script, nil
+}
+
+// findBrew returns the first brew in the installer's locations, or ""
+func findBrew() string {
+	for _, path := range brewLocations {
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+	}
+	return ""
+}
+
+// loadShellenv evaluates brew shellenv in a shell and takes over the PATH,
+// MANPATH, INFOPATH and HOMEBREW_ variables it sets, so commands run by
+// later steps find brew and the packages it installs
+func loadShellenv(run *stepRun, brew string) error {
+	if run.dryRun {
+		run.record(PlannedAction{Kind: ActionNote, Note: fmt.Sprintf("load the environment from %s shellenv", brew)})
+		return nil
+	}
+	output, err := exec.CommandContext(run.ctx, "/bin/bash", "-c", `eval "$("$0" shellenv)" && env -0`, brew).Output()
+	if err != nil {
+		return fmt.Errorf("%s shellenv failed: %w", brew, err)
+	}
+	for _, entry := range bytes.Split(output, []byte{0}) {
+		key, value, ok := strings.Cut(string(entry), "=")
+		if !ok || (!containsString(shellenvVars, key) && !strings.HasPrefix(key, "HOMEBREW_")) {
+			continue
+		}
+		if os.Getenv(key) != value {
+			os.Setenv(key, value)
+			logger.Printf("[%s] %s=%s from brew shellenv", run.step.ID(), key, value)
+		}
+	}
+	run.output("# loaded the environment from " + brew + " shellenv")
+	return nil
+}
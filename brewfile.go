@@ -0,0 +1,366 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Brewfile entry kinds, in the order they are listed
+const (
+	BrewTap     = "tap"
+	BrewFormula = "brew"
+	BrewCask    = "cask"
+	BrewMas     = "mas"
+	BrewVSCode  = "vscode"
+)
+
+// brewKinds lists the entry kinds with their display names
+var brewKinds = []struct{ kind, title string }{
+	{BrewTap, "Taps"},
+	{BrewFormula, "Formulae"},
+	{BrewCask, "Casks"},
+	{BrewMas, "App Store apps"},
+	{BrewVSCode, "VS Code extensions"},
+}
+
+// brewRemovePrefix starts a comment that removes an entry of an earlier
+// Brewfile when Brewfiles are merged, e.g. `# macdevtui:remove brew "node"`.
+// brew bundle itself ignores it.
+const brewRemovePrefix = "# macdevtui:remove "
+
+// brewEntryPattern matches `kind "name"` with optional options after a comma
+var brewEntryPattern = regexp.MustCompile(`^(tap|brew|cask|mas|vscode)\s+(?:"([^"]*)"|'([^']*)')\s*(?:,\s*(.*))?$`)
+
+// BrewEntry is one package line of a Brewfile
+type BrewEntry struct {
+	Kind    string // tap, brew, cask, mas or vscode
+	Name    string
+	Options string // Text after the name, such as `restart_service: true` or `id: 497799835`
+	Raw     string // The entry as written, used when generating Brewfiles
+	Source  string // Brewfile the entry comes from
+}
+
+// Key identifies the entry across Brewfiles, e.g. "brew neovim"
+func (e BrewEntry) Key() string {
+	return e.Kind + " " + e.Name
+}
+
+// String renders the entry for lists, with its options
+func (e BrewEntry) String() string {
+	if e.Options == "" {
+		return e.Name
+	}
+	return fmt.Sprintf("%s (%s)", e.Name, e.Options)
+}
+
+// BrewStatement is one statement of a Brewfile in source order: a package
+// entry, referred to by its key, or any other statement kept verbatim
+type BrewStatement struct {
+	Entry  string // Key of the entry, empty for other statements
+	Text   string // The statement as written
+	Source string // Brewfile the statement comes from
+}
+
+// Brewfile is a parsed Brewfile. Only package lines are understood; other
+// statements such as cask_args or Ruby conditionals are kept verbatim, in
+// order with the entries, so generated Brewfiles still carry them.
+type Brewfile struct {
+	Path       string
+	Entries    []BrewEntry
+	Statements []BrewStatement
+	Removals   []BrewEntry // Entries to drop from earlier Brewfiles when merging
+	Sources    []string    // The Brewfiles merged into this one, when there are several
+}
+
+// readBrewfile reads and parses the Brewfile at path
+func readBrewfile(path string) (*Brewfile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseBrewfile(path, string(data)), nil
+}
+
+// parseBrewfile parses Brewfile text. Statements continue over several
+// lines while brackets or braces are open.
+func parseBrewfile(path, text string) *Brewfile {
+	brewfile := &Brewfile{Path: path}
+	var statement []string
+	depth := 0
+	for _, line := range strings.Split(text, "\n") {
+		if rest, ok := strings.CutPrefix(strings.TrimSpace(line), brewRemovePrefix); ok && len(statement) == 0 {
+			if match := brewEntryPattern.FindStringSubmatch(strings.TrimSpace(rest)); match != nil {
+				brewfile.Removals = append(brewfile.Removals, newBrewEntry(match, path))
+			}
+			continue
+		}
+		code := stripRubyComment(line)
+		if len(statement) == 0 && strings.TrimSpace(code) == "" {
+			continue
+		}
+		statement = append(statement, strings.TrimSpace(code))
+		depth += strings.Count(code, "[") + strings.Count(code, "{") - strings.Count(code, "]") - strings.Count(code, "}")
+		if depth > 0 || strings.HasSuffix(strings.TrimSpace(code), ",") {
+			continue
+		}
+
+		raw := strings.Join(statement, " ")
+		statement, depth = nil, 0
+		if match := brewEntryPattern.FindStringSubmatch(raw); match != nil {
+			entry := newBrewEntry(match, path)
+			brewfile.Entries = append(brewfile.Entries, entry)
+			brewfile.Statements = append(brewfile.Statements, BrewStatement{Entry: entry.Key(), Text: raw, Source: path})
+		} else {
+			brewfile.Statements = append(brewfile.Statements, BrewStatement{Text: raw, Source: path})
+		}
+	}
+	if len(statement) > 0 {
+		brewfile.Statements = append(brewfile.Statements, BrewStatement{Text: strings.Join(statement, " "), Source: path})
+	}
+	return brewfile
+}
+
+// newBrewEntry builds an entry from a brewEntryPattern match
+func newBrewEntry(match []string, source string) BrewEntry {
+	name := match[2]
+	if name == "" {
+		name = match[3]
+	}
+	return BrewEntry{Kind: match[1], Name: name, Options: match[4], Raw: match[0], Source: source}
+}
+
+// mergeBrewfiles layers Brewfiles in order: an entry already listed is
+// replaced in place by a later one with the same key, so its options come
+// from the last file, and removals drop entries of earlier files. Every
+// other statement is kept, even when an earlier file has the same one, in
+// the order of its file so conditionals keep their bodies. A replaced entry
+// is written where the later file lists it.
+func mergeBrewfiles(brewfiles []*Brewfile) *Brewfile {
+	merged := &Brewfile{}
+	index := make(map[string]int)
+	for _, brewfile := range brewfiles {
+		merged.Sources = append(merged.Sources, brewfile.Path)
+		for _, removal := range brewfile.Removals {
+			if i, ok := index[removal.Key()]; ok {
+				merged.Entries = append(merged.Entries[:i], merged.Entries[i+1:]...)
+				delete(index, removal.Key())
+				for key, j := range index {
+					if j > i {
+						index[key] = j - 1
+					}
+				}
+				merged.dropStatements(removal.Key())
+				merged.Removals = append(merged.Removals, removal)
+			}
+		}
+		for _, entry := range brewfile.Entries {
+			if i, ok := index[entry.Key()]; ok {
+				merged.Entries[i] = entry
+				merged.dropStatements(entry.Key())
+				continue
+			}
+			index[entry.Key()] = len(merged.Entries)
+			merged.Entries = append(merged.Entries, entry)
+		}
+		merged.Statements = append(merged.Statements, brewfile.Statements...)
+	}
+	merged.Path = strings.Join(merged.Sources, " + ")
+	return merged
+}
+
+// dropStatements removes the statements of the entry with key
+func (b *Brewfile) dropStatements(key string) {
+	kept := b.Statements[:0]
+	for _, statement := range b.Statements {
+		if statement.Entry != key {
+			kept = append(kept, statement)
+		}
+	}
+	b.Statements = kept
+}
+
+// stripRubyComment drops a trailing # comment that is not inside a string
+func stripRubyComment(line string) string {
+	var quote rune
+	for i, c := range line {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// Grouped returns the entries by kind
+func (b *Brewfile) Grouped() map[string][]BrewEntry {
+	groups := make(map[string][]BrewEntry)
+	for _, entry := range b.Entries {
+		groups[entry.Kind] = append(groups[entry.Kind], entry)
+	}
+	return groups
+}
+
+// ByKind returns the entries ordered by kind as in brewKinds, keeping
+// Brewfile order within each kind
+func (b *Brewfile) ByKind() []BrewEntry {
+	var entries []BrewEntry
+	groups := b.Grouped()
+	for _, kind := range brewKinds {
+		entries = append(entries, groups[kind.kind]...)
+	}
+	return entries
+}
+
+// brewKindTitle returns the display name of an entry kind
+func brewKindTitle(kind string) string {
+	for _, k := range brewKinds {
+		if k.kind == kind {
+			return k.title
+		}
+	}
+	return kind
+}
+
+// Selected returns the entries whose keys are not in skip
+func (b *Brewfile) Selected(skip map[string]bool) []BrewEntry {
+	var selected []BrewEntry
+	for _, entry := range b.Entries {
+		if !skip[entry.Key()] {
+			selected = append(selected, entry)
+		}
+	}
+	return selected
+}
+
+// Skipped reports how many of the entries are in skip
+func (b *Brewfile) Skipped(skip map[string]bool) int {
+	return len(b.Entries) - len(b.Selected(skip))
+}
+
+// Render writes a Brewfile holding the statements in order, with only the
+// given entries among the package lines. Statements of merged Brewfiles
+// are headed by a comment naming the file they come from.
+func (b *Brewfile) Render(entries []BrewEntry) string {
+	selected := make(map[string]BrewEntry)
+	for _, entry := range entries {
+		selected[entry.Key()] = entry
+	}
+	var lines []string
+	source := ""
+	for _, statement := range b.Statements {
+		text := statement.Text
+		if statement.Entry != "" {
+			entry, ok := selected[statement.Entry]
+			if !ok {
+				continue
+			}
+			text = entry.Raw
+		}
+		if len(b.Sources) > 1 && statement.Source != source {
+			source = statement.Source
+			lines = append(lines, "# "+source)
+		}
+		lines = append(lines, text)
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// Digest returns the sha256 of the Brewfile rendered with entries, which
+// identifies a generated Brewfile across runs where its path does not
+func (b *Brewfile) Digest(entries []BrewEntry) string {
+	sum := sha256.Sum256([]byte(b.Render(entries)))
+	return hex.EncodeToString(sum[:])
+}
+
+// writeTempBrewfile writes a generated Brewfile holding entries and returns
+// its path; the caller removes it
+func writeTempBrewfile(brewfile *Brewfile, entries []BrewEntry) (string, error) {
+	tmp, err := os.CreateTemp("", "macdevtui-Brewfile-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to generate Brewfile: %w", err)
+	}
+	_, err = tmp.WriteString(brewfile.Render(entries))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to generate Brewfile: %w", err)
+	}
+	return tmp.Name(), nil
+}
+
+// findBrewfiles returns the configured Brewfiles that exist, in order. In
+// first mode only the first of them is returned.
+func findBrewfiles(config *InstallConfig) ([]string, error) {
+	var found []string
+	for _, brewPath := range config.Homebrew.BrewfilePaths {
+		expandedPath := expandPath(brewPath)
+		if _, err := os.Stat(expandedPath); err == nil {
+			found = append(found, expandedPath)
+			if config.Homebrew.BrewfileMode != BrewfileMerge {
+				break
+			}
+		}
+	}
+	if len(found) == 0 {
+		return nil, fmt.Errorf("no Brewfile found in expected locations: %v", config.Homebrew.BrewfilePaths)
+	}
+	return found, nil
+}
+
+// loadBrewfile reads the Brewfile the Homebrew step installs from: the
+// first one found, or in merge mode all of them layered together
+func loadBrewfile(config *InstallConfig) (*Brewfile, error) {
+	paths, err := findBrewfiles(config)
+	if err != nil {
+		return nil, err
+	}
+	var brewfiles []*Brewfile
+	for _, path := range paths {
+		brewfile, err := readBrewfile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Brewfile %s: %w", path, err)
+		}
+		brewfiles = append(brewfiles, brewfile)
+	}
+	if len(brewfiles) == 1 {
+		return brewfiles[0], nil
+	}
+	return mergeBrewfiles(brewfiles), nil
+}
+
+// describeBrewfile summarises the packages of each kind on one line each
+func describeBrewfile(brewfile *Brewfile) []string {
+	var lines []string
+	groups := brewfile.Grouped()
+	for _, kind := range brewKinds {
+		entries := groups[kind.kind]
+		if len(entries) == 0 {
+			continue
+		}
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name)
+		}
+		lines = append(lines, fmt.Sprintf("%s (%d): %s", kind.title, len(entries), strings.Join(names, ", ")))
+	}
+	if len(brewfile.Sources) > 1 && len(brewfile.Removals) > 0 {
+		var removed []string
+		for _, removal := range brewfile.Removals {
+			removed = append(removed, removal.Key())
+		}
+		lines = append(lines, "Removed by later Brewfiles: "+strings.Join(removed, ", "))
+	}
+	return lines
+}
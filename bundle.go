@@ -0,0 +1,112 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Package states parsed from brew bundle output
+const (
+	PackageUsing     = "up to date"
+	PackageInstalled = "installed"
+	PackageFailed    = "failed"
+)
+
+// Lines brew bundle prints for each Brewfile entry, such as "Using bat",
+// "Installing neovim", "Installing neovim has failed!" or "Skipping install
+// of gh formula. It is already installed."
+var (
+	bundleFailedPattern  = regexp.MustCompile(`^(?:Installing|Upgrading|Tapping|Using) (\S+) has failed!`)
+	bundleStartPattern   = regexp.MustCompile(`^(Using|Installing|Upgrading|Tapping) (\S+)`)
+	bundleSkippedPattern = regexp.MustCompile(`^Skipping install of (\S+) `)
+)
+
+// bundleTracker follows brew bundle output package by package
+type bundleTracker struct {
+	total  int               // Entries in the Brewfile being installed
+	status map[string]string // Package state by name
+	order  []string          // Packages in the order they were first seen
+}
+
+// newBundleTracker starts tracking an install of total Brewfile entries
+func newBundleTracker(total int) *bundleTracker {
+	return &bundleTracker{total: total, status: make(map[string]string)}
+}
+
+// Feed parses one line of output. It returns the package the line is
+// about and what is happening to it, or ok false for other lines.
+func (t *bundleTracker) Feed(line string) (name, action string, ok bool) {
+	line = strings.TrimSpace(line)
+	if match := bundleFailedPattern.FindStringSubmatch(line); match != nil {
+		t.set(match[1], PackageFailed)
+		return match[1], "failed", true
+	}
+	if match := bundleSkippedPattern.FindStringSubmatch(line); match != nil {
+		t.set(match[1], PackageUsing)
+		return match[1], "using", true
+	}
+	if match := bundleStartPattern.FindStringSubmatch(line); match != nil {
+		state := PackageInstalled
+		if match[1] == "Using" {
+			state = PackageUsing
+		}
+		t.set(match[2], state)
+		return match[2], strings.ToLower(match[1]), true
+	}
+	return "", "", false
+}
+
+// set records the latest state of a package; a retried package that
+// succeeds is no longer failed
+func (t *bundleTracker) set(name, state string) {
+	if _, seen := t.status[name]; !seen {
+		t.order = append(t.order, name)
+	}
+	t.status[name] = state
+}
+
+// Done returns how many packages have been seen
+func (t *bundleTracker) Done() int {
+	return len(t.order)
+}
+
+// Fraction returns how much of the Brewfile has been worked through, 0-1
+func (t *bundleTracker) Fraction() float64 {
+	if t.total == 0 {
+		return 0
+	}
+	return min(float64(t.Done())/float64(t.total), 1)
+}
+
+// Packages returns the packages in the given state, sorted
+func (t *bundleTracker) Packages(state string) []string {
+	var names []string
+	for _, name := range t.order {
+		if t.status[name] == state {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// HomebrewStatus tracks what brew bundle did to each package
+type HomebrewStatus struct {
+	Installed      []string // Installed, upgraded or tapped
+	Using          []string // Already up to date
+	Failed         []string
+	LockMismatches []string // Installed versions that differ from the lock file
+}
+
+// Global variable to track Homebrew status
+var homebrewStatus HomebrewStatus
+
+// Status summarises the tracked packages for the report
+func (t *bundleTracker) Status() HomebrewStatus {
+	return HomebrewStatus{
+		Installed: t.Packages(PackageInstalled),
+		Using:     t.Packages(PackageUsing),
+		Failed:    t.Packages(PackageFailed),
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestBundleTrackerFixture feeds recorded brew bundle output through the
+// tracker, line by line as execCommand does
+func TestBundleTrackerFixture(t *testing.T) {
+	data, err := os.ReadFile("testdata/brew-bundle.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker := newBundleTracker(10)
+	seen := 0
+	for _, line := range strings.Split(string(data), "\n") {
+		if _, _, ok := tracker.Feed(line); ok {
+			seen++
+		}
+	}
+
+	want := HomebrewStatus{
+		Installed: []string{"docker", "homebrew/bundle", "neovim", "ripgrep"},
+		Using:     []string{"gh", "git", "jandedobbeleer/oh-my-posh"},
+		Failed:    []string{"kitty"},
+	}
+	if got := tracker.Status(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Status() = %+v, want %+v", got, want)
+	}
+	if got := tracker.Done(); got != 8 {
+		t.Errorf("Done() = %d, want 8", got)
+	}
+	if got := tracker.Fraction(); got != 0.8 {
+		t.Errorf("Fraction() = %v, want 0.8", got)
+	}
+	// Every entry line and retry counts, the noise lines do not
+	if seen != 11 {
+		t.Errorf("Feed matched %d lines, want 11", seen)
+	}
+}
+
+func TestBundleTrackerFeed(t *testing.T) {
+	tests := []struct {
+		line, name, action string
+		ok                 bool
+	}{
+		{"Using git", "git", "using", true},
+		{"Installing neovim", "neovim", "installing", true},
+		{"Upgrading ripgrep", "ripgrep", "upgrading", true},
+		{"Tapping homebrew/bundle", "homebrew/bundle", "tapping", true},
+		{"Skipping install of gh formula. It is already installed.", "gh", "using", true},
+		{"Installing kitty has failed!", "kitty", "failed", true},
+		{"==> Downloading https://ghcr.io/v2/homebrew/core/neovim/manifests/0.10.0", "", "", false},
+		{"==> Upgrading ripgrep", "", "", false},
+		{"Homebrew Bundle failed! 1 Brewfile dependency failed to install.", "", "", false},
+		{"", "", "", false},
+	}
+	for _, test := range tests {
+		name, action, ok := newBundleTracker(1).Feed(test.line)
+		if name != test.name || action != test.action || ok != test.ok {
+			t.Errorf("Feed(%q) = %q, %q, %v, want %q, %q, %v", test.line, name, action, ok, test.name, test.action, test.ok)
+		}
+	}
+}
+
+// A package that fails and then installs on a retry is no longer failed,
+// and counts once towards progress
+func TestBundleTrackerRetry(t *testing.T) {
+	tracker := newBundleTracker(2)
+	for _, line := range []string{"Installing docker", "Installing docker has failed!", "Installing docker"} {
+		tracker.Feed(line)
+	}
+	if status := tracker.Status(); len(status.Failed) != 0 || !reflect.DeepEqual(status.Installed, []string{"docker"}) {
+		t.Errorf("Status() = %+v, want docker installed", status)
+	}
+	if got := tracker.Fraction(); got != 0.5 {
+		t.Errorf("Fraction() = %v, want 0.5", got)
+	}
+	if got := newBundleTracker(0).Fraction(); got != 0 {
+		t.Errorf("Fraction() with no entries = %v, want 0", got)
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// checkpointPath is where the state of an unfinished run is persisted
+var checkpointPath = filepath.Join(currentDir, ".macdevtui-state.json")
+
+// Checkpoint is the persisted state of an installation run, used to resume
+// an interrupted or failed run without redoing completed work
+type Checkpoint struct {
+	ConfigHash        string              `json:"config_hash"`
+	StepHashes        map[string]string   `json:"step_hashes"`
+	CompletedSteps    []string            `json:"completed_steps"`
+	CompletedCommands map[string][]string `json:"completed_commands"`
+	UpdatedAt         time.Time           `json:"updated_at"`
+
+	mu sync.Mutex
+}
+
+// fingerprint returns a short stable hash of the JSON encoding of values
+func fingerprint(values ...interface{}) string {
+	hash := sha256.New()
+	for _, value := range values {
+		data, err := json.Marshal(value)
+		if err != nil {
+			data = []byte(fmt.Sprint(value))
+		}
+		hash.Write(data)
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:16]
+}
+
+// newCheckpoint starts an empty checkpoint for the given configuration
+func newCheckpoint(config *InstallConfig) *Checkpoint {
+	checkpoint := &Checkpoint{
+		ConfigHash:        fingerprint(config),
+		StepHashes:        make(map[string]string),
+		CompletedCommands: make(map[string][]string),
+	}
+	for _, step := range stepRegistry {
+		checkpoint.StepHashes[step.ID()] = step.Fingerprint(config)
+	}
+	return checkpoint
+}
+
+// loadCheckpoint reads the checkpoint left by a previous run, returning
+// nil without error when there is none
+func loadCheckpoint() (*Checkpoint, error) {
+	data, err := os.ReadFile(checkpointPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var checkpoint Checkpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return nil, fmt.Errorf("failed to parse checkpoint %s: %w", checkpointPath, err)
+	}
+	if checkpoint.StepHashes == nil {
+		checkpoint.StepHashes = make(map[string]string)
+	}
+	if checkpoint.CompletedCommands == nil {
+		checkpoint.CompletedCommands = make(map[string][]string)
+	}
+	return &checkpoint, nil
+}
+
+// removeCheckpoint deletes the persisted checkpoint, if any
+func removeCheckpoint() {
+	if err := os.Remove(checkpointPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.Printf("Failed to remove checkpoint: %v", err)
+	}
+}
+
+// Reconcile drops the progress of every step whose configuration changed
+// since the checkpoint was written and returns the IDs of those steps.
+// Afterwards the checkpoint carries the hashes of config.
+func (c *Checkpoint) Reconcile(config *InstallConfig) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Step hashes also cover files the config points at, such as the
+	// Brewfile, so they are compared even when the config is unchanged
+	current := newCheckpoint(config)
+	var changed []string
+	for id, hash := range current.StepHashes {
+		if c.StepHashes[id] == hash {
+			continue
+		}
+		if c.hasProgressLocked(id) {
+			changed = append(changed, id)
+		}
+		delete(c.CompletedCommands, id)
+		c.CompletedSteps = removeString(c.CompletedSteps, id)
+	}
+	sort.Strings(changed)
+
+	c.ConfigHash = current.ConfigHash
+	c.StepHashes = current.StepHashes
+	return changed
+}
+
+// HasProgress reports whether any completed work is recorded
+func (c *Checkpoint) HasProgress() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.CompletedSteps) > 0 || len(c.CompletedCommands) > 0
+}
+
+func (c *Checkpoint) hasProgressLocked(stepID string) bool {
+	return containsString(c.CompletedSteps, stepID) || len(c.CompletedCommands[stepID]) > 0
+}
+
+// StepDone reports whether the step completed in a previous run
+func (c *Checkpoint) StepDone(stepID string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return containsString(c.CompletedSteps, stepID)
+}
+
+// CommandDone reports whether the command completed in a previous run
+func (c *Checkpoint) CommandDone(stepID string, cmd Command) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return containsString(c.CompletedCommands[stepID], cmd.CheckpointKey())
+}
+
+// MarkStep records a completed step and saves the checkpoint
+func (c *Checkpoint) MarkStep(stepID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !containsString(c.CompletedSteps, stepID) {
+		c.CompletedSteps = append(c.CompletedSteps, stepID)
+	}
+	return c.saveLocked()
+}
+
+// MarkCommand records a completed command and saves the checkpoint
+func (c *Checkpoint) MarkCommand(stepID string, cmd Command) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := cmd.CheckpointKey()
+	if !containsString(c.CompletedCommands[stepID], key) {
+		c.CompletedCommands[stepID] = append(c.CompletedCommands[stepID], key)
+	}
+	return c.saveLocked()
+}
+
+// Save writes the checkpoint to disk
+func (c *Checkpoint) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.saveLocked()
+}
+
+// saveLocked writes through a temporary file so an interrupted save never
+// leaves a truncated checkpoint behind
+func (c *Checkpoint) saveLocked() error {
+	c.UpdatedAt = time.Now()
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := checkpointPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, checkpointPath)
+}
+
+// Summary describes the checkpoint for the resume prompt
+func (c *Checkpoint) Summary() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var names []string
+	for _, id := range c.CompletedSteps {
+		names = append(names, getStepDisplayName(id))
+	}
+	commands := 0
+	for _, keys := range c.CompletedCommands {
+		commands += len(keys)
+	}
+
+	summary := fmt.Sprintf("%d step(s) and %d command(s) done", len(c.CompletedSteps), commands)
+	if len(names) > 0 {
+		summary += " (" + strings.Join(names, ", ") + ")"
+	}
+	return summary + ", saved " + c.UpdatedAt.Format("2006-01-02 15:04")
+}
+
+// commandKey identifies a command within a step's checkpoint entries
+func commandKey(args []string) string {
+	return strings.Join(args, " ")
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func removeString(values []string, value string) []string {
+	var kept []string
+	for _, v := range values {
+		if v != value {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
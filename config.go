@@ -1,62 +1,524 @@
 package main
 
 import (
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 // InstallConfig represents the configuration for the installer
 type InstallConfig struct {
-	Homebrew HombrewConfig  `json:"homebrew"`
-	Shell    ShellConfig    `json:"shell"`
-	DevTools DevToolsConfig `json:"devtools"`
-	Dotfiles DotfilesConfig `json:"dotfiles"`
-	Terminal TerminalConfig `json:"terminal"`
+	Homebrew    HombrewConfig     `json:"homebrew"`
+	Shell       ShellConfig       `json:"shell"`
+	DevTools    DevToolsConfig    `json:"devtools"`
+	Dotfiles    DotfilesConfig    `json:"dotfiles"`
+	Terminal    TerminalConfig    `json:"terminal"`
+	Packages    PackagesConfig    `json:"packages,omitempty"`
+	MaxParallel int               `json:"max_parallel,omitempty"` // Steps that may run at once
+	Vars        map[string]string `json:"vars,omitempty"`         // Variables for templated files
+}
+
+// defaultMaxParallel is used when max_parallel is not set
+const defaultMaxParallel = 3
+
+// Failure policies for steps and commands
+const (
+	OnErrorAbort    = "abort"
+	OnErrorContinue = "continue"
+	OnErrorRetry    = "retry"
+)
+
+// Defaults used when a retry policy leaves attempts or backoff unset
+const (
+	defaultRetryAttempts = 3
+	defaultRetryBackoff  = 2 * time.Second
+)
+
+// FailurePolicy controls what happens when a step or command fails
+type FailurePolicy struct {
+	OnError  string `json:"on_error,omitempty"` // abort (default), continue or retry
+	Attempts int    `json:"attempts,omitempty"` // Total attempts when retrying
+	Backoff  string `json:"backoff,omitempty"`  // Delay before the first retry, doubled after each
+}
+
+// StepOptions holds the settings shared by every step section
+type StepOptions struct {
+	FailurePolicy
+	DependsOn []string `json:"depends_on,omitempty"` // Step IDs that must complete first
+	After     []string `json:"-"`                    // Step IDs that must finish first, whatever their outcome
+	Timeout   Timeout  `json:"timeout,omitempty"`    // Limit for each attempt of the step
+}
+
+// Timeout is a duration such as "90s" or "10m" after which a step or
+// command is stopped. Empty means no limit.
+type Timeout string
+
+// withDefaultDependsOn fills in the step's built-in dependencies when the
+// config does not declare any
+func (o StepOptions) withDefaultDependsOn(ids ...string) StepOptions {
+	if o.DependsOn == nil {
+		o.DependsOn = ids
+	}
+	return o
+}
+
+// Workers returns how many steps may run at once
+func (c *InstallConfig) Workers() int {
+	if c.MaxParallel < 1 {
+		return defaultMaxParallel
+	}
+	return c.MaxParallel
+}
+
+// Command is a single command entry. In JSON it is either a plain argument
+// list or an object with the list under "cmd" plus its own failure policy.
+type Command struct {
+	Args []string `json:"cmd"`
+	FailurePolicy
+	Timeout Timeout `json:"timeout,omitempty"` // Limit for each attempt of the command
+	Key     string  `json:"-"`                 // Checkpoint key for commands whose Args differ between runs
 }
 
 // HombrewConfig contains Homebrew-related configuration
 type HombrewConfig struct {
 	Install       bool     `json:"install"`
 	BrewfilePaths []string `json:"brewfile_paths"`
+	BrewfileMode  string   `json:"brewfile_mode,omitempty"` // first (default) or merge
+	LockFile      string   `json:"lock_file,omitempty"`     // Versions resolved by a successful install, compared on later ones
+	LockMode      string   `json:"lock_mode,omitempty"`     // warn (default) or strict
+	StepOptions
+
+	// How Homebrew itself is installed when brew is missing
+	InstallerURL    string `json:"installer_url,omitempty"`    // Install script to download, defaults to the official one
+	InstallerSHA256 string `json:"installer_sha256,omitempty"` // Expected checksum of the script, or "skip" to run a download unverified
+	InstallerFile   string `json:"installer_file,omitempty"`   // Local install script to use instead of downloading
+}
+
+// LockModeOrDefault returns the lock mode, warn when unset
+func (h HombrewConfig) LockModeOrDefault() string {
+	if h.LockMode == "" {
+		return LockWarn
+	}
+	return h.LockMode
+}
+
+// Installer returns the install script settings for Homebrew
+func (h HombrewConfig) Installer() InstallerConfig {
+	return InstallerConfig{URL: h.InstallerURL, SHA256: h.InstallerSHA256, File: h.InstallerFile}
+}
+
+// InstallerUnpinned as an installer checksum runs a downloaded install
+// script without verifying it
+const InstallerUnpinned = "skip"
+
+// InstallerConfig says where a package manager's install script comes from
+// and what it must hash to
+type InstallerConfig struct {
+	URL    string `json:"url,omitempty"`    // Install script to download, defaults to the official one
+	SHA256 string `json:"sha256,omitempty"` // Expected checksum of the script, or "skip" to run a download unverified
+	File   string `json:"file,omitempty"`   // Local install script to use instead of downloading
+}
+
+// validateInstaller checks installer settings; prefix names them in errors,
+// e.g. "homebrew: installer_"
+func validateInstaller(prefix string, installer InstallerConfig) error {
+	if url := installer.URL; url != "" && !strings.HasPrefix(url, "https://") {
+		return fmt.Errorf("%surl must use https: %s", prefix, url)
+	}
+	if sum := installer.SHA256; sum != "" && sum != InstallerUnpinned {
+		if _, err := hex.DecodeString(sum); err != nil || len(sum) != 64 {
+			return fmt.Errorf("%ssha256 must be 64 hex digits or %q", prefix, InstallerUnpinned)
+		}
+	}
+	return nil
+}
+
+// How the Brewfiles in brewfile_paths are used
+const (
+	BrewfileFirst = "first" // The first one that exists
+	BrewfileMerge = "merge" // Every one that exists, layered in order
+)
+
+// PackagesConfig picks the package manager the homebrew step uses and
+// holds the package lists of the Linux backends. Homebrew installs from the
+// Brewfiles instead.
+type PackagesConfig struct {
+	Manager string   `json:"manager,omitempty"` // auto (default), homebrew, apt, dnf, pacman or nix
+	Apt     []string `json:"apt,omitempty"`
+	Dnf     []string `json:"dnf,omitempty"`
+	Pacman  []string `json:"pacman,omitempty"`
+	Nix     []string `json:"nix,omitempty"`
+
+	NixInstaller InstallerConfig `json:"nix_installer,omitzero"` // How Nix itself is installed when nix is missing
 }
 
 // ShellConfig contains shell setup configuration
 type ShellConfig struct {
-	Install       bool       `json:"install"`
-	RequiredTools []string   `json:"required_tools"`
-	ShellFiles    []string   `json:"shell_files"`
-	ThemeFile     string     `json:"theme_file"`
-	InitCommands  [][]string `json:"init_commands"`
+	Install       bool        `json:"install"`
+	RequiredTools []string    `json:"required_tools"`
+	ShellFiles    []ShellFile `json:"shell_files"`
+	Manage        string      `json:"manage,omitempty"` // file (default) or block, for every shell file
+	ThemeFile     string      `json:"theme_file"`
+	InitCommands  []Command   `json:"init_commands"`
+	StepOptions
+}
+
+// How shell files are installed: replacing the whole file, or only a
+// delimited block inside it
+const (
+	ManageFile  = "file"
+	ManageBlock = "block"
+)
+
+// ShellFile is a shell startup file, relative to this repo and to the home
+// directory. In JSON it is either the path or an object with the path under
+// "path" plus its own manage setting.
+type ShellFile struct {
+	Path   string `json:"path"`
+	Manage string `json:"manage,omitempty"` // Overrides the section's manage setting
 }
 
 // DevToolsConfig contains development tools configuration
 type DevToolsConfig struct {
 	Install     bool       `json:"install"`
 	Languages   []Language `json:"languages"`
-	GlobalTools [][]string `json:"global_tools"`
+	GlobalTools []Command  `json:"global_tools"`
 	VerifyTools []string   `json:"verify_tools"`
+	StepOptions
 }
 
 // Language represents a programming language configuration
 type Language struct {
-	Name     string     `json:"name"`
-	Enabled  bool       `json:"enabled"`
-	Commands [][]string `json:"commands"`
+	Name     string    `json:"name"`
+	Enabled  bool      `json:"enabled"`
+	Commands []Command `json:"commands"`
 }
 
 // DotfilesConfig contains dotfiles restoration configuration
 type DotfilesConfig struct {
-	Install  bool              `json:"install"`
-	Mappings map[string]string `json:"mappings"`
+	Install   bool               `json:"install"`
+	Mappings  map[string]Mapping `json:"mappings"`
+	Templates []string           `json:"templates,omitempty"` // Source patterns rendered as templates, besides *.tmpl
+	Ignore    []string           `json:"ignore,omitempty"`    // Gitignore-style patterns left out of every directory copy
+	LinkOptions
+	StepOptions
 }
 
 // TerminalConfig contains terminal configuration
 type TerminalConfig struct {
-	Install     bool              `json:"install"`
-	ConfigFiles map[string]string `json:"config_files"`
+	Install     bool               `json:"install"`
+	ConfigFiles map[string]Mapping `json:"config_files"`
+	LinkOptions
+	StepOptions
+}
+
+// Install modes for mapped files
+const (
+	ModeCopy    = "copy"
+	ModeSymlink = "symlink"
+)
+
+// Link styles and conflict policies for symlink mode
+const (
+	LinkRelative   = "relative"
+	LinkAbsolute   = "absolute"
+	ConflictBackup = "backup"
+	ConflictRefuse = "refuse"
+)
+
+// LinkOptions controls how mapped files are installed. Set on a config
+// section it applies to every mapping; set on a mapping it overrides the
+// section for that mapping.
+type LinkOptions struct {
+	Mode       string `json:"mode,omitempty"`        // copy (default) or symlink
+	Link       string `json:"link,omitempty"`        // relative (default) or absolute symlinks
+	OnConflict string `json:"on_conflict,omitempty"` // backup (default) or refuse existing files in the way of a link
+}
+
+// Mapping is the destination of a mapped file, relative to the home
+// directory. In JSON it is either the destination path or an object with
+// the path under "dest" plus its own link options and ignore patterns.
+type Mapping struct {
+	Dest   string   `json:"dest"`
+	Ignore []string `json:"ignore,omitempty"` // Gitignore-style patterns for directory mappings
+	LinkOptions
+}
+
+// UnmarshalJSON accepts either ["cmd", "arg"] or {"cmd": [...], "on_error": ..., "timeout": ...}
+func (c *Command) UnmarshalJSON(data []byte) error {
+	var args []string
+	if err := json.Unmarshal(data, &args); err == nil {
+		*c = Command{Args: args}
+		return nil
+	}
+
+	type plain Command // Avoid recursing into this method
+	var cmd plain
+	if err := json.Unmarshal(data, &cmd); err != nil {
+		return fmt.Errorf("command must be an argument list or an object with \"cmd\": %w", err)
+	}
+	*c = Command(cmd)
+	return nil
+}
+
+// MarshalJSON writes commands without a policy or timeout as plain argument lists
+func (c Command) MarshalJSON() ([]byte, error) {
+	if c.FailurePolicy == (FailurePolicy{}) && c.Timeout == "" {
+		return json.Marshal(c.Args)
+	}
+	type plain Command
+	return json.Marshal(plain(c))
+}
+
+// UnmarshalJSON accepts either "dest" or {"dest": ..., "mode": ...}
+func (m *Mapping) UnmarshalJSON(data []byte) error {
+	var dest string
+	if err := json.Unmarshal(data, &dest); err == nil {
+		*m = Mapping{Dest: dest}
+		return nil
+	}
+
+	type plain Mapping // Avoid recursing into this method
+	var mapping plain
+	if err := json.Unmarshal(data, &mapping); err != nil {
+		return fmt.Errorf("mapping must be a destination path or an object with \"dest\": %w", err)
+	}
+	*m = Mapping(mapping)
+	return nil
+}
+
+// MarshalJSON writes mappings without link options or ignore patterns as plain paths
+func (m Mapping) MarshalJSON() ([]byte, error) {
+	if m.LinkOptions == (LinkOptions{}) && len(m.Ignore) == 0 {
+		return json.Marshal(m.Dest)
+	}
+	type plain Mapping
+	return json.Marshal(plain(m))
+}
+
+// UnmarshalJSON accepts either "path" or {"path": ..., "manage": ...}
+func (f *ShellFile) UnmarshalJSON(data []byte) error {
+	var path string
+	if err := json.Unmarshal(data, &path); err == nil {
+		*f = ShellFile{Path: path}
+		return nil
+	}
+
+	type plain ShellFile // Avoid recursing into this method
+	var file plain
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("shell file must be a path or an object with \"path\": %w", err)
+	}
+	*f = ShellFile(file)
+	return nil
+}
+
+// MarshalJSON writes shell files without a manage setting as plain paths
+func (f ShellFile) MarshalJSON() ([]byte, error) {
+	if f.Manage == "" {
+		return json.Marshal(f.Path)
+	}
+	type plain ShellFile
+	return json.Marshal(plain(f))
+}
+
+// ManageMode returns how the file is installed given the section default
+func (f ShellFile) ManageMode(defaults string) string {
+	switch {
+	case f.Manage != "":
+		return f.Manage
+	case defaults != "":
+		return defaults
+	}
+	return ManageFile
+}
+
+// validateManage checks a manage setting
+func validateManage(manage string) error {
+	switch manage {
+	case "", ManageFile, ManageBlock:
+		return nil
+	}
+	return fmt.Errorf("unknown manage %q (use file or block)", manage)
+}
+
+// Resolve fills the options a mapping leaves unset from the section
+// defaults, then from the built-in ones
+func (o LinkOptions) Resolve(defaults LinkOptions) LinkOptions {
+	if o.Mode == "" {
+		o.Mode = defaults.Mode
+	}
+	if o.Link == "" {
+		o.Link = defaults.Link
+	}
+	if o.OnConflict == "" {
+		o.OnConflict = defaults.OnConflict
+	}
+	if o.Mode == "" {
+		o.Mode = ModeCopy
+	}
+	if o.Link == "" {
+		o.Link = LinkRelative
+	}
+	if o.OnConflict == "" {
+		o.OnConflict = ConflictBackup
+	}
+	return o
+}
+
+// Symlink reports whether the options ask for a link instead of a copy
+func (o LinkOptions) Symlink() bool {
+	return o.Mode == ModeSymlink
+}
+
+// Validate checks that every option names a known value
+func (o LinkOptions) Validate() error {
+	switch o.Mode {
+	case "", ModeCopy, ModeSymlink:
+	default:
+		return fmt.Errorf("unknown mode %q (use copy or symlink)", o.Mode)
+	}
+	switch o.Link {
+	case "", LinkRelative, LinkAbsolute:
+	default:
+		return fmt.Errorf("unknown link %q (use relative or absolute)", o.Link)
+	}
+	switch o.OnConflict {
+	case "", ConflictBackup, ConflictRefuse:
+	default:
+		return fmt.Errorf("unknown on_conflict %q (use backup or refuse)", o.OnConflict)
+	}
+	return nil
+}
+
+// String renders the command as a shell-like line
+func (c Command) String() string {
+	return strings.Join(c.Args, " ")
+}
+
+// CheckpointKey identifies the command within a step's checkpoint entries:
+// its Key when set, otherwise its arguments
+func (c Command) CheckpointKey() string {
+	if c.Key != "" {
+		return c.Key
+	}
+	return commandKey(c.Args)
+}
+
+// Settings describes the command's non-default policy and timeout for plans
+func (c Command) Settings() string {
+	var settings []string
+	if policy := c.FailurePolicy.String(); policy != "" {
+		settings = append(settings, policy)
+	}
+	if c.Timeout != "" {
+		settings = append(settings, "timeout: "+string(c.Timeout))
+	}
+	return strings.Join(settings, ", ")
+}
+
+// Duration returns the limit, or 0 when there is none
+func (t Timeout) Duration() time.Duration {
+	d, err := time.ParseDuration(string(t))
+	if err != nil || d < 0 {
+		return 0
+	}
+	return d
+}
+
+// Validate checks that the timeout is empty or a positive duration
+func (t Timeout) Validate() error {
+	if t == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(string(t))
+	if err != nil {
+		return fmt.Errorf("invalid timeout %q: %w", string(t), err)
+	}
+	if d <= 0 {
+		return fmt.Errorf("timeout %q must be positive", string(t))
+	}
+	return nil
+}
+
+// Continues reports whether a failure should be recorded and skipped
+func (p FailurePolicy) Continues() bool {
+	return p.OnError == OnErrorContinue
+}
+
+// MaxAttempts returns how many times to try before giving up
+func (p FailurePolicy) MaxAttempts() int {
+	if p.OnError != OnErrorRetry {
+		return 1
+	}
+	if p.Attempts < 1 {
+		return defaultRetryAttempts
+	}
+	return p.Attempts
+}
+
+// RetryDelay returns the backoff before the given retry, starting at 1
+func (p FailurePolicy) RetryDelay(retry int) time.Duration {
+	delay := defaultRetryBackoff
+	if d, err := time.ParseDuration(p.Backoff); err == nil {
+		delay = d
+	}
+	for i := 1; i < retry; i++ {
+		delay *= 2
+	}
+	return delay
+}
+
+// String describes a non-default policy, or returns "" for abort
+func (p FailurePolicy) String() string {
+	switch p.OnError {
+	case OnErrorContinue:
+		return "on_error: continue"
+	case OnErrorRetry:
+		return fmt.Sprintf("on_error: retry, %d attempts, %s backoff", p.MaxAttempts(), p.RetryDelay(1))
+	}
+	return ""
+}
+
+// Validate checks that the policy names a known mode and a usable backoff
+func (p FailurePolicy) Validate() error {
+	switch p.OnError {
+	case "", OnErrorAbort, OnErrorContinue, OnErrorRetry:
+	default:
+		return fmt.Errorf("unknown on_error policy %q (use abort, continue or retry)", p.OnError)
+	}
+	if p.Attempts < 0 {
+		return fmt.Errorf("attempts cannot be negative")
+	}
+	if p.Backoff != "" {
+		if _, err := time.ParseDuration(p.Backoff); err != nil {
+			return fmt.Errorf("invalid backoff %q: %w", p.Backoff, err)
+		}
+	}
+	return nil
+}
+
+// validateMappings checks a section's link options and those of its mappings
+func validateMappings(section string, options LinkOptions, mappings map[string]Mapping) error {
+	if err := options.Validate(); err != nil {
+		return fmt.Errorf("%s: %w", section, err)
+	}
+	for src, mapping := range mappings {
+		if mapping.Dest == "" {
+			return fmt.Errorf("%s: mapping for %s has no destination", section, src)
+		}
+		if err := mapping.LinkOptions.Validate(); err != nil {
+			return fmt.Errorf("%s: mapping for %s: %w", section, src, err)
+		}
+		if _, err := newIgnoreMatcher(mapping.Ignore); err != nil {
+			return fmt.Errorf("%s: mapping for %s: %w", section, src, err)
+		}
+	}
+	return nil
 }
 
 // LoadConfig loads configuration from JSON file
@@ -119,18 +581,51 @@ func (c *InstallConfig) SaveConfig(path string) error {
 
 // Validate ensures the configuration is valid and safe to use
 func (c *InstallConfig) Validate() error {
-	// Validate Homebrew config
-	if c.Homebrew.Install && len(c.Homebrew.BrewfilePaths) == 0 {
+	// Validate package manager and Homebrew config
+	if name := c.Packages.Manager; name != "" && name != ManagerAuto {
+		if _, ok := packageManagers[name]; !ok {
+			return fmt.Errorf("packages: unknown manager %q (use auto, homebrew, apt, dnf, pacman or nix)", name)
+		}
+	}
+	if c.Homebrew.Install && len(c.Homebrew.BrewfilePaths) == 0 && packageManagerName(c) == ManagerHomebrew {
 		return fmt.Errorf("homebrew is enabled but no brewfile paths specified")
 	}
+	switch c.Homebrew.BrewfileMode {
+	case "", BrewfileFirst, BrewfileMerge:
+	default:
+		return fmt.Errorf("homebrew: unknown brewfile_mode %q (use first or merge)", c.Homebrew.BrewfileMode)
+	}
+	switch c.Homebrew.LockMode {
+	case "", LockWarn, LockStrict:
+	default:
+		return fmt.Errorf("homebrew: unknown lock_mode %q (use warn or strict)", c.Homebrew.LockMode)
+	}
+	if err := validateInstaller("homebrew: installer_", c.Homebrew.Installer()); err != nil {
+		return err
+	}
+	if err := validateInstaller("packages: nix_installer.", c.Packages.NixInstaller); err != nil {
+		return err
+	}
 
 	// Validate shell config
 	if c.Shell.Install {
 		if len(c.Shell.RequiredTools) == 0 {
 			return fmt.Errorf("shell is enabled but no required tools specified")
 		}
+		if err := validateManage(c.Shell.Manage); err != nil {
+			return fmt.Errorf("shell: %w", err)
+		}
+		for _, file := range c.Shell.ShellFiles {
+			if file.Path == "" {
+				return fmt.Errorf("shell: shell file with no path")
+			}
+			if err := validateManage(file.Manage); err != nil {
+				return fmt.Errorf("shell: %s: %w", file.Path, err)
+			}
+		}
 		// Check for potentially dangerous commands in shell init
-		for _, cmd := range c.Shell.InitCommands {
+		for _, command := range c.Shell.InitCommands {
+			cmd := command.Args
 			if len(cmd) == 0 {
 				return fmt.Errorf("empty command in shell init commands")
 			}
@@ -162,15 +657,61 @@ func (c *InstallConfig) Validate() error {
 		}
 	}
 
+	// Validate failure policies and timeouts on steps and command entries
+	for _, step := range stepRegistry {
+		options := step.Options(c)
+		if err := options.FailurePolicy.Validate(); err != nil {
+			return fmt.Errorf("%s: %w", step.ID(), err)
+		}
+		if err := options.Timeout.Validate(); err != nil {
+			return fmt.Errorf("%s: %w", step.ID(), err)
+		}
+	}
+	commands := append([]Command{}, c.Shell.InitCommands...)
+	commands = append(commands, c.DevTools.GlobalTools...)
+	for _, lang := range c.DevTools.Languages {
+		commands = append(commands, lang.Commands...)
+	}
+	for _, cmd := range commands {
+		if err := cmd.FailurePolicy.Validate(); err != nil {
+			return fmt.Errorf("command %q: %w", cmd.String(), err)
+		}
+		if err := cmd.Timeout.Validate(); err != nil {
+			return fmt.Errorf("command %q: %w", cmd.String(), err)
+		}
+	}
+
+	// Validate step dependencies
+	if c.MaxParallel < 0 {
+		return fmt.Errorf("max_parallel cannot be negative")
+	}
+	if err := validateStepGraph(stepRegistry, c); err != nil {
+		return err
+	}
+
 	// Validate dotfiles config
 	if c.Dotfiles.Install && len(c.Dotfiles.Mappings) == 0 {
 		return fmt.Errorf("dotfiles is enabled but no mappings specified")
 	}
+	if err := validateMappings("dotfiles", c.Dotfiles.LinkOptions, c.Dotfiles.Mappings); err != nil {
+		return err
+	}
+	for _, pattern := range c.Dotfiles.Templates {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("dotfiles: invalid template pattern %q: %w", pattern, err)
+		}
+	}
+	if _, err := newIgnoreMatcher(c.Dotfiles.Ignore); err != nil {
+		return fmt.Errorf("dotfiles: %w", err)
+	}
 
 	// Validate terminal config
 	if c.Terminal.Install && len(c.Terminal.ConfigFiles) == 0 {
 		return fmt.Errorf("terminal is enabled but no config files specified")
 	}
+	if err := validateMappings("terminal", c.Terminal.LinkOptions, c.Terminal.ConfigFiles); err != nil {
+		return err
+	}
 
 	return nil
 }
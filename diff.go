@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// diffContext is how many unchanged lines surround each hunk
+const diffContext = 3
+
+// maxDiffCells caps the size of the line comparison table; larger files
+// are summarised instead of diffed
+const maxDiffCells = 4_000_000
+
+// FileChange describes how copying Source would change the existing Dest
+type FileChange struct {
+	StepID  string
+	Source  string
+	Dest    string
+	Binary  bool     // Either side is not text; Summary describes the change
+	Diff    []string // Unified diff lines for text files
+	Summary string   // One-line description of the change
+}
+
+// previewChanges returns the changes a step's planned copies would make
+// to existing files that differ from their source. Directory mappings are
+// covered file by file, as copyDir plans them.
+func previewChanges(step Step, config *InstallConfig) []FileChange {
+	var changes []FileChange
+	for _, action := range step.Plan(config) {
+		if (action.Kind != ActionCopy && action.Kind != ActionBlock) || !action.Overwrite {
+			continue
+		}
+		diff := diffFiles
+		if action.Kind == ActionBlock {
+			diff = diffBlock
+		}
+		change, changed, err := diff(action.Dest, action.Source, config)
+		if err != nil {
+			change = FileChange{Source: action.Source, Dest: action.Dest, Summary: "cannot compare: " + err.Error()}
+		} else if !changed {
+			continue
+		}
+		change.StepID = step.ID()
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// diffFiles compares the current file at dest with what copying src would
+// write, rendering templates first. It reports changed as false when the
+// contents are equal.
+func diffFiles(dest, src string, config *InstallConfig) (change FileChange, changed bool, err error) {
+	change = FileChange{Source: src, Dest: dest}
+	current, err := os.ReadFile(dest)
+	if err != nil {
+		return change, false, err
+	}
+	incoming, err := sourceContent(src, config)
+	if err != nil {
+		return change, false, err
+	}
+	return diffContent(change, current, incoming)
+}
+
+// diffBlock compares the file at dest with the result of setting its
+// managed block to src
+func diffBlock(dest, src string, config *InstallConfig) (change FileChange, changed bool, err error) {
+	change = FileChange{Source: src, Dest: dest}
+	current, err := os.ReadFile(dest)
+	if err != nil {
+		return change, false, err
+	}
+	body, err := sourceContent(src, config)
+	if err != nil {
+		return change, false, err
+	}
+	return diffContent(change, current, setBlock(current, body))
+}
+
+// diffContent fills in change with the differences between the current
+// and incoming contents of its Dest
+func diffContent(change FileChange, current, incoming []byte) (FileChange, bool, error) {
+	dest, src := change.Dest, change.Source
+	if bytes.Equal(current, incoming) {
+		return change, false, nil
+	}
+
+	if isBinary(current) || isBinary(incoming) {
+		change.Binary = true
+		change.Summary = fmt.Sprintf("binary: %s → %s", describeBlob(current), describeBlob(incoming))
+		return change, true, nil
+	}
+
+	oldLines, newLines := splitLines(string(current)), splitLines(string(incoming))
+	if (len(oldLines)+1)*(len(newLines)+1) > maxDiffCells {
+		change.Summary = fmt.Sprintf("too large to diff: %d → %d lines", len(oldLines), len(newLines))
+		return change, true, nil
+	}
+	change.Diff = unifiedDiff(dest, src, oldLines, newLines)
+	added, removed := 0, 0
+	for _, line := range change.Diff[2:] {
+		switch {
+		case strings.HasPrefix(line, "+"):
+			added++
+		case strings.HasPrefix(line, "-"):
+			removed++
+		}
+	}
+	change.Summary = fmt.Sprintf("+%d -%d lines", added, removed)
+	return change, true, nil
+}
+
+// isBinary treats content with NUL bytes or invalid UTF-8 as binary
+func isBinary(data []byte) bool {
+	sample := data
+	if len(sample) > 8000 {
+		sample = sample[:8000]
+	}
+	return bytes.IndexByte(sample, 0) >= 0 || !utf8.Valid(sample)
+}
+
+// describeBlob renders the size and a short hash of binary content
+func describeBlob(data []byte) string {
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf("%d bytes (sha256 %s)", len(data), hex.EncodeToString(sum[:])[:12])
+}
+
+// splitLines splits text into lines without their terminators
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// diffOp is one line of an edit script: ' ' keep, '-' delete, '+' insert
+type diffOp struct {
+	kind     byte
+	line     string
+	old, new int // Line indexes in the old and new text
+}
+
+// unifiedDiff renders the differences between a and b in unified format
+func unifiedDiff(aName, bName string, a, b []string) []string {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i], i, j})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			// Deletions come before insertions, as in diff -u
+			ops = append(ops, diffOp{'-', a[i], i, j})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j], i, j})
+			j++
+		}
+	}
+
+	lines := []string{"--- " + aName, "+++ " + bName}
+	for start := 0; start < len(ops); {
+		// Find the next change and the extent of its hunk
+		for start < len(ops) && ops[start].kind == ' ' {
+			start++
+		}
+		if start == len(ops) {
+			break
+		}
+		first := max(start-diffContext, 0)
+		end := start
+		for k := start; k < len(ops); k++ {
+			if ops[k].kind != ' ' {
+				end = k
+			} else if k-end > 2*diffContext {
+				break
+			}
+		}
+		last := min(end+diffContext, len(ops)-1)
+
+		oldCount, newCount := 0, 0
+		var body []string
+		for _, op := range ops[first : last+1] {
+			if op.kind != '+' {
+				oldCount++
+			}
+			if op.kind != '-' {
+				newCount++
+			}
+			body = append(body, string(op.kind)+op.line)
+		}
+		lines = append(lines, fmt.Sprintf("@@ -%s +%s @@",
+			hunkRange(ops[first].old, oldCount), hunkRange(ops[first].new, newCount)))
+		lines = append(lines, body...)
+		start = last + 1
+	}
+	return lines
+}
+
+// hunkRange renders a hunk's start line and length, 1-based
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
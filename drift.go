@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// bundleCheckPattern matches what brew bundle check --verbose prints for an
+// entry that is not installed, e.g. "→ Formula neovim needs to be installed
+// or updated." or "→ Tap homebrew/cask-fonts needs to be tapped."
+var bundleCheckPattern = regexp.MustCompile(`^→ (.+?) needs to be`)
+
+// Drift compares the effective Brewfile with what Homebrew has installed.
+// It only reads; nothing is installed or removed.
+type Drift struct {
+	Brewfile string      // Brewfile checked, or the merged Brewfiles
+	Missing  []BrewEntry // Selected entries that are not installed
+	Extra    []BrewEntry // Formulae installed on request and casks that no entry declares
+	Outdated []BrewEntry // Entries with a newer version available
+}
+
+// Clean reports whether nothing drifted
+func (d *Drift) Clean() bool {
+	return len(d.Missing) == 0 && len(d.Extra) == 0 && len(d.Outdated) == 0
+}
+
+// Summary counts the drift on one line
+func (d *Drift) Summary() string {
+	if d.Clean() {
+		return "Installed packages match the Brewfile"
+	}
+	return fmt.Sprintf("%d missing, %d not in the Brewfile, %d outdated", len(d.Missing), len(d.Extra), len(d.Outdated))
+}
+
+// Lines lists the drift by category, for the TUI and the command line
+func (d *Drift) Lines() []string {
+	lines := []string{"Checked " + d.Brewfile, "", d.Summary()}
+	sections := []struct {
+		title   string
+		entries []BrewEntry
+	}{
+		{"Missing (declared but not installed)", d.Missing},
+		{"Extra (installed but not declared)", d.Extra},
+		{"Outdated", d.Outdated},
+	}
+	for _, section := range sections {
+		if len(section.entries) == 0 {
+			continue
+		}
+		lines = append(lines, "", fmt.Sprintf("%s (%d)", section.title, len(section.entries)))
+		for _, entry := range section.entries {
+			lines = append(lines, fmt.Sprintf("  %s %s", entry.Kind, entry.Name))
+		}
+	}
+	return lines
+}
+
+// checkDrift compares the Brewfile the Homebrew step would install, less
+// the packages in skip, with the installed packages. Deselected entries
+// are neither missing nor extra.
+func checkDrift(ctx context.Context, config *InstallConfig, skip map[string]bool) (*Drift, error) {
+	if _, err := exec.LookPath("brew"); err != nil {
+		return nil, fmt.Errorf("Homebrew is not installed")
+	}
+	brewfile, err := loadBrewfile(config)
+	if err != nil {
+		return nil, err
+	}
+	drift := &Drift{Brewfile: brewfile.Path}
+	selected := brewfile.Selected(skip)
+
+	missing, err := bundleMissing(ctx, brewfile, selected)
+	if err != nil {
+		return nil, err
+	}
+	drift.Missing = missing
+
+	// Extra packages: formulae installed on request, not as dependencies,
+	// and casks that no Brewfile entry names
+	declared := make(map[string]bool)
+	for _, entry := range brewfile.Entries {
+		declared[entry.Kind+" "+shortBrewName(entry.Name)] = true
+	}
+	for _, kind := range []struct{ kind, flag string }{{BrewFormula, "--installed-on-request"}, {BrewCask, "--cask"}} {
+		args := []string{"list", "-1", kind.flag}
+		if kind.kind == BrewFormula {
+			args = append(args, "--formula")
+		}
+		output, err := brewOutput(ctx, args...)
+		if err != nil {
+			return nil, fmt.Errorf("brew list failed: %w", err)
+		}
+		for _, name := range strings.Fields(output) {
+			if !declared[kind.kind+" "+name] {
+				drift.Extra = append(drift.Extra, BrewEntry{Kind: kind.kind, Name: name})
+			}
+		}
+	}
+
+	// Outdated packages, limited to the declared ones; dependencies are
+	// upgraded along with them
+	output, err := brewOutput(ctx, "outdated", "--quiet")
+	if err != nil {
+		return nil, fmt.Errorf("brew outdated failed: %w", err)
+	}
+	outdated := make(map[string]bool)
+	for _, name := range strings.Fields(output) {
+		outdated[shortBrewName(name)] = true
+	}
+	for _, entry := range selected {
+		if (entry.Kind == BrewFormula || entry.Kind == BrewCask) && outdated[shortBrewName(entry.Name)] {
+			drift.Outdated = append(drift.Outdated, entry)
+		}
+	}
+
+	sort.Slice(drift.Extra, func(i, j int) bool { return drift.Extra[i].Key() < drift.Extra[j].Key() })
+	return drift, nil
+}
+
+// bundleMissing returns the entries that brew bundle check reports as not
+// installed, checking a Brewfile generated from entries
+func bundleMissing(ctx context.Context, brewfile *Brewfile, entries []BrewEntry) ([]BrewEntry, error) {
+	path, err := writeTempBrewfile(brewfile, entries)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(path)
+
+	// It exits non-zero when something is missing, so only a run that
+	// printed nothing useful counts as failed
+	output, err := brewOutput(ctx, "bundle", "check", "--verbose", "--no-upgrade", "--file="+path)
+	var missing []BrewEntry
+	reported := 0
+	for _, line := range strings.Split(output, "\n") {
+		match := bundleCheckPattern.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil {
+			continue
+		}
+		reported++
+		if entry, ok := findDescribedEntry(entries, match[1]); ok {
+			missing = append(missing, entry)
+		}
+	}
+	if err != nil && reported == 0 {
+		return nil, fmt.Errorf("brew bundle check failed: %w", err)
+	}
+	return missing, nil
+}
+
+// findDescribedEntry returns the entry brew bundle check describes, such as
+// "Formula neovim" or "App Xcode", by matching the end of the description
+func findDescribedEntry(entries []BrewEntry, description string) (BrewEntry, bool) {
+	for _, entry := range entries {
+		if description == entry.Name || strings.HasSuffix(description, " "+entry.Name) ||
+			strings.HasSuffix(description, " "+shortBrewName(entry.Name)) {
+			return entry, true
+		}
+	}
+	return BrewEntry{}, false
+}
+
+// shortBrewName drops the tap from a fully qualified name such as
+// "homebrew/cask-fonts/font-fira-code", as brew list prints it
+func shortBrewName(name string) string {
+	return name[strings.LastIndex(name, "/")+1:]
+}
+
+// brewOutput runs brew without auto-updating and returns its standard
+// output, with the end of its standard error in a failure. It is killed
+// when ctx is cancelled.
+func brewOutput(ctx context.Context, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "brew", args...)
+	cmd.Env = append(os.Environ(), "HOMEBREW_NO_AUTO_UPDATE=1")
+	output, err := cmd.Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+		err = fmt.Errorf("%w: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return string(output), err
+}
+
+// cleanupDrift uninstalls the extra packages found by checkDrift and
+// returns those it removed
+func cleanupDrift(ctx context.Context, drift *Drift) ([]string, error) {
+	var removed []string
+	for _, kind := range []string{BrewFormula, BrewCask} {
+		var names []string
+		for _, entry := range drift.Extra {
+			if entry.Kind == kind {
+				names = append(names, entry.Name)
+			}
+		}
+		if len(names) == 0 {
+			continue
+		}
+		flag := "--formula"
+		if kind == BrewCask {
+			flag = "--cask"
+		}
+		if _, err := brewOutput(ctx, append([]string{"uninstall", flag}, names...)...); err != nil {
+			return removed, fmt.Errorf("brew uninstall failed: %w", err)
+		}
+		logger.Printf("Removed packages not in the Brewfile: %s", strings.Join(names, ", "))
+		removed = append(removed, names...)
+	}
+	return removed, nil
+}
+
+// runDriftCheck prints how the installed packages differ from the Brewfile
+// and, with cleanup, offers to uninstall the ones it does not declare
+func runDriftCheck(cleanup bool) error {
+	config, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+	drift, err := checkDrift(context.Background(), config, nil)
+	if err != nil {
+		return err
+	}
+	for _, line := range drift.Lines() {
+		fmt.Fprintln(os.Stdout, line)
+	}
+	if !cleanup || len(drift.Extra) == 0 {
+		return nil
+	}
+
+	fmt.Fprintf(os.Stdout, "Uninstall the %d package(s) not in the Brewfile? [y/N] ", len(drift.Extra))
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	if answer = strings.TrimSpace(answer); answer != "y" && answer != "Y" {
+		fmt.Fprintln(os.Stdout, "Nothing removed")
+		return nil
+	}
+	removed, err := cleanupDrift(context.Background(), drift)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "Removed %d package(s) not in the Brewfile\n", len(removed))
+	return nil
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// stepDependencies returns the dependencies of step that are part of ids,
+// both those that must complete and those it only runs after.
+// Dependencies on steps outside the set, such as disabled ones, are ignored.
+func stepDependencies(step Step, config *InstallConfig, ids map[string]bool) []string {
+	var deps []string
+	options := step.Options(config)
+	for _, dep := range append(append([]string{}, options.DependsOn...), options.After...) {
+		if ids[dep] && dep != step.ID() {
+			deps = append(deps, dep)
+		}
+	}
+	return deps
+}
+
+// sortSteps orders steps so every step comes after its dependencies,
+// keeping the given order wherever dependencies allow it. It fails when
+// the dependencies form a cycle.
+func sortSteps(steps []Step, config *InstallConfig) ([]Step, error) {
+	ids := make(map[string]bool)
+	for _, step := range steps {
+		ids[step.ID()] = true
+	}
+
+	placed := make(map[string]bool)
+	var sorted []Step
+	for len(sorted) < len(steps) {
+		progressed := false
+		for _, step := range steps {
+			if placed[step.ID()] {
+				continue
+			}
+			ready := true
+			for _, dep := range stepDependencies(step, config, ids) {
+				if !placed[dep] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				placed[step.ID()] = true
+				sorted = append(sorted, step)
+				progressed = true
+			}
+		}
+		if !progressed {
+			return nil, fmt.Errorf("dependency cycle between steps: %s", describeCycle(steps, config, placed))
+		}
+	}
+	return sorted, nil
+}
+
+// describeCycle follows unplaced dependencies from the first unplaced step
+// until a step repeats, and renders that loop as "a → b → a"
+func describeCycle(steps []Step, config *InstallConfig, placed map[string]bool) string {
+	ids := make(map[string]bool)
+	byID := make(map[string]Step)
+	for _, step := range steps {
+		ids[step.ID()] = true
+		byID[step.ID()] = step
+	}
+
+	var path []string
+	seen := make(map[string]int)
+	for _, step := range steps {
+		if placed[step.ID()] {
+			continue
+		}
+		current := step.ID()
+		for {
+			if at, ok := seen[current]; ok {
+				return strings.Join(append(path[at:], current), " → ")
+			}
+			seen[current] = len(path)
+			path = append(path, current)
+
+			next := ""
+			for _, dep := range stepDependencies(byID[current], config, ids) {
+				if !placed[dep] {
+					next = dep
+					break
+				}
+			}
+			if next == "" {
+				break
+			}
+			current = next
+		}
+		break
+	}
+	return strings.Join(path, ", ")
+}
+
+// validateStepGraph checks that every declared dependency names a known
+// step and that the dependencies of all steps are free of cycles
+func validateStepGraph(steps []Step, config *InstallConfig) error {
+	ids := make(map[string]bool)
+	for _, step := range steps {
+		ids[step.ID()] = true
+	}
+	for _, step := range steps {
+		for _, dep := range step.Options(config).DependsOn {
+			if dep == step.ID() {
+				return fmt.Errorf("%s: step cannot depend on itself", step.ID())
+			}
+			if !ids[dep] {
+				return fmt.Errorf("%s: depends_on names unknown step %q", step.ID(), dep)
+			}
+		}
+	}
+	_, err := sortSteps(steps, config)
+	return err
+}
+
+// stepResult is sent by a step's goroutine when it finishes
+type stepResult struct {
+	step Step
+	err  error
+}
+
+// runSteps runs steps as soon as their dependencies have finished, with at
+// most workers steps at a time. A failed step whose policy is to abort, or
+// cancellation, stops new steps from starting, unless only commands whose
+// policy is to continue failed in it; steps that depend on a step
+// that did not complete are skipped, while steps that only run after it
+// still run. It returns once no step is running.
+func (inst *installation) runSteps(steps []Step, workers int) error {
+	sorted, err := sortSteps(steps, inst.config)
+	if err != nil {
+		return err
+	}
+
+	ids := make(map[string]bool)
+	for _, step := range sorted {
+		ids[step.ID()] = true
+	}
+
+	finished := make(map[string]InstallStatus)
+	results := make(chan stepResult)
+	pending := sorted
+	running := 0
+	stopped := false
+
+	for {
+		if !stopped && inst.ctx.Err() == nil {
+			// pending is in dependency order, so a skip propagates to
+			// dependents within this single pass
+			var waiting []Step
+			for _, step := range pending {
+				ready := true
+				blocker := ""
+				required := step.Options(inst.config).DependsOn
+				for _, dep := range stepDependencies(step, inst.config, ids) {
+					status, done := finished[dep]
+					if !done {
+						ready = false
+					} else if status != StatusComplete && containsString(required, dep) {
+						blocker = dep
+						break
+					}
+				}
+
+				switch {
+				case blocker != "":
+					logger.Printf("Skipping step %s: dependency %s did not complete", step.ID(), blocker)
+					inst.blockStep(step, blocker)
+					finished[step.ID()] = StatusSkipped
+				case ready && running < workers:
+					running++
+					logger.Printf("Executing step: %s", step.ID())
+					go func(step Step) {
+						results <- stepResult{step: step, err: inst.runStep(step)}
+					}(step)
+				default:
+					waiting = append(waiting, step)
+				}
+			}
+			pending = waiting
+		}
+
+		if running == 0 {
+			return nil
+		}
+
+		result := <-results
+		running--
+		id := result.step.ID()
+		switch {
+		case result.err == nil:
+			logger.Printf("Step %s completed successfully", id)
+			finished[id] = StatusComplete
+		case isCancellation(result.err):
+			logger.Printf("Step %s interrupted", id)
+			finished[id] = StatusCancelled
+			stopped = true
+		default:
+			logger.Printf("Step %s failed: %v", id, result.err)
+			finished[id] = StatusError
+			if !result.step.Options(inst.config).Continues() && !isContinued(result.err) {
+				logger.Println("Not starting further steps after failed step")
+				stopped = true
+			}
+		}
+	}
+}
+
+// blockStep reports a step that cannot run because a dependency failed
+func (inst *installation) blockStep(step Step, blocker string) {
+	err := fmt.Errorf("skipped: depends on %s, which did not complete", blocker)
+	progress := inst.recordOutcome(stepOutcome{StepID: step.ID(), Status: StatusSkipped, Err: err})
+	inst.send(InstallMsg{
+		Event:    EventStepFinished,
+		StepID:   step.ID(),
+		Status:   StatusSkipped,
+		Progress: progress,
+		Error:    err,
+		Message:  fmt.Sprintf("%s skipped", step.Title()),
+	})
+}
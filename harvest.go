@@ -0,0 +1,233 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// harvestChanges compares the home-side versions of every dotfile and
+// terminal mapping with the repo and returns the files harvesting would
+// copy back. Source is the file in the home directory and Dest the file in
+// the repo. Mappings that cannot or need not be harvested are described in
+// notes instead.
+func harvestChanges(config *InstallConfig) (changes []FileChange, notes []string) {
+	type mapped struct {
+		stepID  string
+		src     string
+		mapping Mapping
+		options LinkOptions
+	}
+	var all []mapped
+	for src, mapping := range config.Dotfiles.Mappings {
+		all = append(all, mapped{"dotfiles", src, mapping, mapping.LinkOptions.Resolve(config.Dotfiles.LinkOptions)})
+	}
+	for src, mapping := range config.Terminal.ConfigFiles {
+		all = append(all, mapped{"terminal", src, mapping, mapping.LinkOptions.Resolve(config.Terminal.LinkOptions)})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].src < all[j].src })
+
+	for _, m := range all {
+		repoPath := filepath.Join(currentDir, m.src)
+		homePath := filepath.Join(homeDir, templateDest(m.src, m.mapping.Dest))
+
+		if linked, _ := linkState(repoPath, homePath); linked {
+			continue // Edits already land in the repo
+		}
+		if isTemplate(repoPath, config) {
+			notes = append(notes, fmt.Sprintf("%s is a template; edit it in the repo", m.src))
+			continue
+		}
+		info, err := os.Stat(homePath)
+		if err != nil {
+			notes = append(notes, fmt.Sprintf("%s not found at %s", m.src, homePath))
+			continue
+		}
+
+		if !info.IsDir() {
+			if change, ok := harvestFile(m.stepID, homePath, repoPath, config); ok {
+				changes = append(changes, change)
+			}
+			continue
+		}
+		ignore, err := mappingIgnore(repoPath, m.mapping, config)
+		if err != nil {
+			notes = append(notes, fmt.Sprintf("%s: %v", m.src, err))
+			continue
+		}
+		filepath.Walk(homePath, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			rel, err := filepath.Rel(homePath, path)
+			if err != nil || rel == "." {
+				return nil
+			}
+			if ignore.Ignored(rel, info.IsDir()) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if info.IsDir() {
+				return nil
+			}
+			// A rendered file comes from foo.tmpl or a file matching the
+			// template patterns; the repo keeps the template
+			repoFile := filepath.Join(repoPath, rel)
+			if _, err := os.Lstat(repoFile + templateSuffix); err == nil {
+				repoFile += templateSuffix
+			}
+			if isTemplate(repoFile, config) {
+				src, _ := filepath.Rel(currentDir, repoFile)
+				notes = append(notes, fmt.Sprintf("%s is a template; edit it in the repo", src))
+				return nil
+			}
+			if change, ok := harvestFile(m.stepID, path, repoFile, config); ok {
+				changes = append(changes, change)
+			}
+			return nil
+		})
+	}
+	return changes, notes
+}
+
+// harvestFile compares one home file with its repo counterpart, reporting
+// ok when harvesting would change the repo
+func harvestFile(stepID, homePath, repoPath string, config *InstallConfig) (FileChange, bool) {
+	change := FileChange{StepID: stepID, Source: homePath, Dest: repoPath}
+
+	homeInfo, err := os.Lstat(homePath)
+	if err != nil {
+		return change, false
+	}
+	if homeInfo.Mode()&os.ModeSymlink != 0 {
+		target, _ := os.Readlink(homePath)
+		if repoTarget, err := os.Readlink(repoPath); err == nil && repoTarget == target {
+			return change, false
+		}
+		change.Summary = "symlink to " + target
+		return change, true
+	}
+	if !homeInfo.Mode().IsRegular() {
+		return change, false
+	}
+
+	if _, err := os.Lstat(repoPath); errors.Is(err, os.ErrNotExist) {
+		change.Summary = "new file"
+		return change, true
+	}
+	change, changed, err := diffFiles(repoPath, homePath, config)
+	change.StepID = stepID
+	if err != nil {
+		change.Summary = "cannot compare: " + err.Error()
+		return change, true
+	}
+	return change, changed
+}
+
+// applyHarvest copies the home-side files of changes into the repo,
+// leaving out those whose repo path is in keep. It returns the repo paths
+// it wrote.
+func applyHarvest(changes []FileChange, keep map[string]bool) ([]string, error) {
+	var harvested []string
+	for _, change := range changes {
+		if keep[change.Dest] {
+			continue
+		}
+		info, err := os.Lstat(change.Source)
+		if err != nil {
+			return harvested, err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(change.Source)
+			if err != nil {
+				return harvested, err
+			}
+			if err := os.MkdirAll(filepath.Dir(change.Dest), 0755); err != nil {
+				return harvested, err
+			}
+			os.Remove(change.Dest)
+			err = os.Symlink(target, change.Dest)
+		} else {
+			err = copyFile(change.Source, change.Dest)
+		}
+		if err != nil {
+			return harvested, fmt.Errorf("failed to harvest %s: %w", change.Source, err)
+		}
+		logger.Printf("Harvested %s into %s", change.Source, change.Dest)
+		harvested = append(harvested, change.Dest)
+	}
+	return harvested, nil
+}
+
+// dumpBrewfile records the installed Homebrew packages with brew bundle
+// dump, into the first configured Brewfile that exists, or the first one
+func dumpBrewfile(config *InstallConfig) (string, error) {
+	if len(config.Homebrew.BrewfilePaths) == 0 {
+		return "", fmt.Errorf("no brewfile paths configured")
+	}
+	path := expandPath(config.Homebrew.BrewfilePaths[0])
+	for _, brewPath := range config.Homebrew.BrewfilePaths {
+		if _, err := os.Stat(expandPath(brewPath)); err == nil {
+			path = expandPath(brewPath)
+			break
+		}
+	}
+
+	output, err := exec.Command("brew", "bundle", "dump", "--force", "--file="+path).CombinedOutput()
+	if err != nil {
+		return path, fmt.Errorf("brew bundle dump failed: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	logger.Printf("Dumped Brewfile to %s", path)
+	return path, nil
+}
+
+// runHarvest prints what harvesting would change and, unless dryRun is
+// set, copies the home-side files into the repo
+func runHarvest(dryRun, brewDump bool) error {
+	config, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+
+	changes, notes := harvestChanges(config)
+	for _, note := range notes {
+		fmt.Fprintf(os.Stdout, "note: %s\n", note)
+	}
+	if len(changes) == 0 {
+		fmt.Fprintln(os.Stdout, "Repo is up to date with the home directory")
+	}
+	for _, change := range changes {
+		fmt.Fprintf(os.Stdout, "%s → %s  %s\n", change.Source, change.Dest, change.Summary)
+		for _, line := range change.Diff {
+			fmt.Fprintf(os.Stdout, "    %s\n", line)
+		}
+	}
+
+	if dryRun {
+		if brewDump {
+			fmt.Fprintln(os.Stdout, "would run brew bundle dump")
+		}
+		return nil
+	}
+
+	harvested, err := applyHarvest(changes, nil)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "Harvested %d file(s) into %s\n", len(harvested), currentDir)
+
+	if brewDump {
+		path, err := dumpBrewfile(config)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stdout, "Recorded installed packages in %s\n", path)
+	}
+	return nil
+}
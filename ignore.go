@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ignoreFileName is the optional file of ignore patterns at the root of a
+// copied directory. It is never copied itself.
+const ignoreFileName = ".macdevtuiignore"
+
+// ignoreRule is one compiled gitignore-style pattern
+type ignoreRule struct {
+	pattern string
+	negate  bool // "!pattern" re-includes what earlier rules ignored
+	dirOnly bool // "pattern/" only matches directories
+	re      *regexp.Regexp
+}
+
+// ignoreMatcher decides which paths of a directory copy are left out,
+// following gitignore rules: the last matching pattern wins, patterns
+// without a slash match at any depth, and ignoring a directory ignores
+// everything below it. A nil matcher ignores nothing.
+type ignoreMatcher struct {
+	rules []ignoreRule
+}
+
+// newIgnoreMatcher compiles patterns in order; blank lines and lines
+// starting with # are skipped
+func newIgnoreMatcher(patterns []string) (*ignoreMatcher, error) {
+	matcher := &ignoreMatcher{}
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" || strings.HasPrefix(pattern, "#") {
+			continue
+		}
+		rule := ignoreRule{pattern: pattern}
+		if strings.HasPrefix(pattern, "!") {
+			rule.negate = true
+			pattern = pattern[1:]
+		}
+		if strings.HasSuffix(pattern, "/") {
+			rule.dirOnly = true
+			pattern = strings.TrimRight(pattern, "/")
+		}
+		anchored := strings.Contains(pattern, "/")
+		pattern = strings.TrimPrefix(pattern, "/")
+		if pattern == "" {
+			return nil, fmt.Errorf("invalid ignore pattern %q", rule.pattern)
+		}
+
+		expr := globToRegexp(pattern)
+		if !anchored {
+			expr = "(.*/)?" + expr
+		}
+		re, err := regexp.Compile("^" + expr + "$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid ignore pattern %q: %w", rule.pattern, err)
+		}
+		rule.re = re
+		matcher.rules = append(matcher.rules, rule)
+	}
+	return matcher, nil
+}
+
+// globToRegexp translates a gitignore glob into a regular expression
+func globToRegexp(glob string) string {
+	var expr strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; {
+		case strings.HasPrefix(glob[i:], "**/"):
+			expr.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "/**") && i+3 == len(glob):
+			expr.WriteString("(/.*)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case c == '*':
+			expr.WriteString("[^/]*")
+		case c == '?':
+			expr.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i:], ']')
+			if end < 0 {
+				expr.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + class + "]")
+			i += end
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return expr.String()
+}
+
+// Ignored reports whether the path, relative to the copied directory, is
+// left out
+func (m *ignoreMatcher) Ignored(rel string, isDir bool) bool {
+	if m == nil {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	ignored := false
+	for _, rule := range m.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.re.MatchString(rel) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// loadIgnore builds the matcher for copying the directory at src from the
+// given pattern lists, followed by the lines of src's .macdevtuiignore
+func loadIgnore(src string, patternLists ...[]string) (*ignoreMatcher, error) {
+	var patterns []string
+	for _, list := range patternLists {
+		patterns = append(patterns, list...)
+	}
+
+	file, err := os.Open(filepath.Join(src, ignoreFileName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			patterns = append(patterns, scanner.Text())
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	matcher, err := newIgnoreMatcher(append(patterns, "/"+ignoreFileName))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", src, err)
+	}
+	return matcher, nil
+}
+
+// mappingIgnore returns the matcher for a directory mapping: the global
+// dotfiles patterns, then the mapping's own, then the .macdevtuiignore file
+func mappingIgnore(src string, mapping Mapping, config *InstallConfig) (*ignoreMatcher, error) {
+	return loadIgnore(src, config.Dotfiles.Ignore, mapping.Ignore)
+}
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
 	"fmt"
+	"io"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 	"time"
@@ -15,7 +17,7 @@ import (
 var (
 	currentDir, _ = os.Getwd()
 	homeDir, _    = os.UserHomeDir()
-	logger        *log.Logger
+	logger        = log.New(io.Discard, "", log.LstdFlags)
 )
 
 // initLogger sets up logging to a file
@@ -58,197 +60,246 @@ func expandPaths(paths []string) []string {
 }
 
 // expandCommands expands path variables in command arguments
-func expandCommands(commands [][]string) [][]string {
-	expanded := make([][]string, len(commands))
+func expandCommands(commands []Command) []Command {
+	expanded := make([]Command, len(commands))
 	for i, cmd := range commands {
-		expanded[i] = expandPaths(cmd)
+		expanded[i] = cmd
+		expanded[i].Args = expandPaths(cmd.Args)
 	}
 	return expanded
 }
 
 // InstallMsg represents an installation progress message
 type InstallMsg struct {
-	StepID   string
-	Status   InstallStatus
-	Progress int
-	Message  string
-	Error    error
+	Event        InstallEvent
+	StepID       string
+	Status       InstallStatus
+	Progress     int // Overall progress across enabled steps, 0-100
+	StepProgress int // Progress within StepID, 0-100
+	Attempt      int // Attempt number for EventStepStarted
+	Message      string
+	Error        error
 }
 
 // DotfilesStatus tracks dotfiles installation status
 type DotfilesStatus struct {
 	CopiedFiles    []string
+	LinkedFiles    []string
 	MissingFiles   []string
 	IsCleanInstall bool
 }
 
-// Global variables to track executed steps and dotfiles status
-var (
-	executedSteps  []string
-	dotfilesStatus DotfilesStatus
-)
+// Global variable to track dotfiles status
+var dotfilesStatus DotfilesStatus
+
+// TerminalStatus tracks terminal configuration status
+type TerminalStatus struct {
+	MissingFiles []string
+}
+
+// Global variable to track terminal status
+var terminalStatus TerminalStatus
+
+// StartInstallation begins the installation process for enabled steps.
+// Progress is pushed to the running program while the returned command
+// works; the command itself returns the final EventInstallFinished message.
+// Cancelling ctx stops the running command and skips the remaining steps.
+// When resume is set, work recorded in it is skipped instead of redone.
+func (m Model) StartInstallation(ctx context.Context, resume *Checkpoint) tea.Cmd {
+	// Copy the review decisions; the model keeps changing while the run works
+	keepFiles := make(map[string]bool)
+	for dest, keep := range m.keepFiles {
+		keepFiles[dest] = keep
+	}
+	skipPackages := make(map[string]bool)
+	for key, skip := range m.skipPackages {
+		skipPackages[key] = skip
+	}
 
-// StartInstallation begins the installation process for enabled steps
-func (m Model) StartInstallation() tea.Cmd {
 	return func() tea.Msg {
 		// Initialize logger
 		initLogger()
 		logger.Println("Starting installation process")
-		
-		// Reset executed steps tracking
-		executedSteps = []string{}
 
-		// Process each enabled step sequentially
+		var enabled []Step
 		for _, step := range m.steps {
 			if !step.Enabled {
 				logger.Printf("Skipping disabled step: %s", step.ID)
 				continue
 			}
+			impl, ok := lookupStep(step.ID)
+			if !ok {
+				logger.Printf("Skipping unregistered step: %s", step.ID)
+				continue
+			}
+			enabled = append(enabled, impl)
+		}
 
-			// Track that this step is being executed
-			logger.Printf("Executing step: %s", step.ID)
-			executedSteps = append(executedSteps, step.ID)
-
-			var err error
-			switch step.ID {
-			case "homebrew":
-				err = installHomebrew()
-			case "terminal":
-				err = configureTerminal()
-			case "shell":
-				err = configureShell()
-			case "devtools":
-				err = installDevTools()
-			case "dotfiles":
-				err = restoreDotfiles()
-			case "verify":
-				err = verifyInstallation()
+		inst := newInstallation(ctx, m.config, len(enabled))
+		inst.keepFiles = keepFiles
+		inst.skipPackages = skipPackages
+		if resume != nil {
+			logger.Printf("Resuming from checkpoint: %s", resume.Summary())
+			inst.checkpoint = resume
+		}
+		if err := inst.checkpoint.Save(); err != nil {
+			logger.Printf("Failed to save checkpoint: %v", err)
+		}
+
+		// Run steps along the dependency graph, several at a time, stopping
+		// new work when cancelled or when a failed step's policy is to abort
+		if err := inst.runSteps(enabled, m.config.Workers()); err != nil {
+			logger.Printf("Invalid step graph: %v", err)
+			return InstallMsg{
+				Event:   EventInstallFinished,
+				Status:  StatusError,
+				Error:   err,
+				Message: "Installation Error",
 			}
+		}
 
-			if err != nil {
-				logger.Printf("Step %s failed: %v", step.ID, err)
-				return InstallMsg{
-					StepID:  step.ID,
-					Status:  StatusError,
-					Error:   err,
-					Message: fmt.Sprintf("Failed: %s", err.Error()),
-				}
-			} else {
-				logger.Printf("Step %s completed successfully", step.ID)
+		if ctx.Err() != nil {
+			logger.Println("Installation cancelled, writing partial report")
+			generateReportAfterInstallation(inst)
+			return InstallMsg{
+				Event:   EventInstallFinished,
+				Status:  StatusCancelled,
+				Error:   ctx.Err(),
+				Message: "Installation cancelled - partial report written",
 			}
 		}
 
-		// All steps completed successfully
-		message := "All installations complete!"
-		if dotfilesStatus.IsCleanInstall && len(dotfilesStatus.MissingFiles) > 0 {
-			message = fmt.Sprintf("Clean install complete! (%d dotfiles not found)", len(dotfilesStatus.MissingFiles))
+		logger.Println("Installation finished, generating report")
+		generateReportAfterInstallation(inst)
+
+		msg := inst.finishedMsg()
+		if msg.Error != nil {
+			return msg
 		}
 
-		// Generate report after all installations complete
-		logger.Println("All installations complete, generating report")
-		generateReportAfterInstallation(executedSteps)
+		// Nothing is left to resume once every step has succeeded
+		removeCheckpoint()
 
-		return InstallMsg{
-			Message:  message,
-			Progress: 100,
+		// All steps completed successfully
+		msg.Message = "All installations complete!"
+		if dotfilesStatus.IsCleanInstall && len(dotfilesStatus.MissingFiles) > 0 {
+			msg.Message = fmt.Sprintf("Clean install complete! (%d dotfiles not found)", len(dotfilesStatus.MissingFiles))
 		}
+		return msg
 	}
 }
 
-// installHomebrew installs Homebrew and packages
-func installHomebrew() error {
-	config, err := LoadConfig()
-	if err != nil {
-		return fmt.Errorf("failed to load config: %w", err)
-	}
-
+// installPackages bootstraps the package manager chosen for this OS and
+// installs its configured packages
+func installPackages(run *stepRun) error {
+	config := run.config
 	if !config.Homebrew.Install {
 		return nil // Skip if disabled
 	}
 
-	// Check if Homebrew is already installed
-	if _, err := exec.LookPath("brew"); err != nil {
-		// Install Homebrew
-		cmd := exec.Command("/bin/bash", "-c",
-			`/bin/bash -c "$(curl -fsSL https://raw.githubusercontent.com/Homebrew/install/HEAD/install.sh)"`)
-		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("failed to install Homebrew: %w", err)
-		}
+	manager, err := selectPackageManager(config)
+	if err != nil {
+		return err
 	}
-
-	// Use configured Brewfile paths (expand any path variables)
-	for _, brewPath := range config.Homebrew.BrewfilePaths {
-		expandedPath := expandPath(brewPath)
-		if _, err := os.Stat(expandedPath); err == nil {
-			cmd := exec.Command("brew", "bundle", "--file="+expandedPath)
-			if err := cmd.Run(); err != nil {
-				return fmt.Errorf("failed to install packages from Brewfile %s: %w", expandedPath, err)
-			}
-			return nil
-		}
+	if err := manager.Bootstrap(run); err != nil {
+		return err
 	}
-	return fmt.Errorf("no Brewfile found in expected locations: %v", config.Homebrew.BrewfilePaths)
-}
-
-// configureTerminal sets up Kitty and Tmux configurations
-func configureTerminal() error {
-	config, err := LoadConfig()
+	packages, err := manager.Packages(config)
 	if err != nil {
-		return fmt.Errorf("failed to load config: %w", err)
+		return err
 	}
+	return manager.Install(run, packages)
+}
 
+// configureTerminal sets up Kitty and Tmux configurations
+func configureTerminal(run *stepRun) error {
+	config := run.config
 	if !config.Terminal.Install {
 		return nil // Skip if disabled
 	}
 
-	// Copy configured terminal files
-	for srcRelPath, destRelPath := range config.Terminal.ConfigFiles {
+	var missingFiles []string
+
+	// Copy or link configured terminal files
+	for srcRelPath, mapping := range config.Terminal.ConfigFiles {
 		srcPath := filepath.Join(currentDir, srcRelPath)
-		destPath := filepath.Join(homeDir, destRelPath)
+		destPath := filepath.Join(homeDir, mapping.Dest)
+
+		// A link to a missing source would dangle in the home directory
+		if _, err := os.Stat(srcPath); err != nil {
+			missingFiles = append(missingFiles, srcRelPath)
+			if run.dryRun {
+				run.record(PlannedAction{Kind: ActionNote, Note: fmt.Sprintf("skip %s (source not found)", srcPath)})
+			}
+			continue
+		}
+
+		options := mapping.LinkOptions.Resolve(config.Terminal.LinkOptions)
+		if options.Symlink() {
+			if err := run.linkFile(srcPath, destPath, options); err != nil {
+				return fmt.Errorf("failed to link %s to %s: %w", destPath, srcPath, err)
+			}
+			continue
+		}
 
 		// Create destination directory
-		if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		if err := run.ensureDir(filepath.Dir(destPath)); err != nil {
 			return fmt.Errorf("failed to create directory for %s: %w", destPath, err)
 		}
 
-		// Copy file
-		if err := copyFile(srcPath, destPath); err != nil {
+		// Copy directory or file
+		if info, err := os.Stat(srcPath); err == nil && info.IsDir() {
+			ignore, err := mappingIgnore(srcPath, mapping, config)
+			if err != nil {
+				return fmt.Errorf("failed to read ignore patterns for %s: %w", srcPath, err)
+			}
+			if err := run.copyDir(srcPath, destPath, ignore); err != nil {
+				return fmt.Errorf("failed to copy directory %s to %s: %w", srcPath, destPath, err)
+			}
+			continue
+		}
+		if err := run.copyFile(srcPath, destPath); err != nil {
 			return fmt.Errorf("failed to copy %s to %s: %w", srcPath, destPath, err)
 		}
 	}
 
+	if !run.dryRun {
+		terminalStatus = TerminalStatus{MissingFiles: missingFiles}
+	}
 	return nil
 }
 
 // configureShell sets up Zsh with Oh-My-Posh and tools
-func configureShell() error {
-	config, err := LoadConfig()
-	if err != nil {
-		return fmt.Errorf("failed to load config: %w", err)
-	}
-
+func configureShell(run *stepRun) error {
+	config := run.config
 	if !config.Shell.Install {
 		return nil // Skip if disabled
 	}
 
 	// Check if required tools are installed
 	for _, tool := range config.Shell.RequiredTools {
-		if _, err := exec.LookPath(tool); err != nil {
+		if err := run.requireTool(tool); err != nil {
 			return fmt.Errorf("%s is not installed: %w", tool, err)
 		}
 	}
 
 	// Ensure .config directory exists
-	if err := os.MkdirAll(filepath.Join(homeDir, ".config"), 0755); err != nil {
+	if err := run.ensureDir(filepath.Join(homeDir, ".config")); err != nil {
 		return fmt.Errorf("failed to create .config directory: %w", err)
 	}
 
-	// Copy configured shell files
+	// Copy configured shell files, or only their managed block
 	for _, file := range config.Shell.ShellFiles {
-		srcFile := filepath.Join(currentDir, file)
-		destFile := filepath.Join(homeDir, file)
-		if err := copyFile(srcFile, destFile); err != nil {
-			return fmt.Errorf("failed to copy %s: %w", file, err)
+		srcFile := filepath.Join(currentDir, file.Path)
+		destFile := filepath.Join(homeDir, file.Path)
+		if file.ManageMode(config.Shell.Manage) == ManageBlock {
+			if err := run.writeBlock(srcFile, destFile); err != nil {
+				return fmt.Errorf("failed to update the managed block in %s: %w", destFile, err)
+			}
+			continue
+		}
+		if err := run.copyFile(srcFile, destFile); err != nil {
+			return fmt.Errorf("failed to copy %s: %w", file.Path, err)
 		}
 	}
 
@@ -256,7 +307,7 @@ func configureShell() error {
 	if config.Shell.ThemeFile != "" {
 		srcTheme := filepath.Join(currentDir, config.Shell.ThemeFile)
 		destTheme := filepath.Join(homeDir, ".config", config.Shell.ThemeFile)
-		if err := copyFile(srcTheme, destTheme); err != nil {
+		if err := run.copyFile(srcTheme, destTheme); err != nil {
 			return fmt.Errorf("failed to copy theme file: %w", err)
 		}
 	}
@@ -264,11 +315,11 @@ func configureShell() error {
 	// Run configured initialization commands (expand any path variables)
 	expandedCommands := expandCommands(config.Shell.InitCommands)
 	for _, cmd := range expandedCommands {
-		if len(cmd) == 0 {
+		if len(cmd.Args) == 0 {
 			continue
 		}
-		if err := exec.Command(cmd[0], cmd[1:]...).Run(); err != nil {
-			return fmt.Errorf("failed to run command %v: %w", cmd, err)
+		if err := run.runCommand(cmd); err != nil {
+			return fmt.Errorf("failed to run command %v: %w", cmd.Args, err)
 		}
 	}
 
@@ -276,12 +327,8 @@ func configureShell() error {
 }
 
 // installDevTools configures development environment
-func installDevTools() error {
-	config, err := LoadConfig()
-	if err != nil {
-		return fmt.Errorf("failed to load config: %w", err)
-	}
-
+func installDevTools(run *stepRun) error {
+	config := run.config
 	if !config.DevTools.Install {
 		return nil // Skip if disabled
 	}
@@ -293,30 +340,30 @@ func installDevTools() error {
 		}
 
 		for _, cmd := range lang.Commands {
-			if len(cmd) == 0 {
+			if len(cmd.Args) == 0 {
 				continue
 			}
 
-			if err := exec.Command(cmd[0], cmd[1:]...).Run(); err != nil {
-				return fmt.Errorf("failed to configure %s with command %v: %w", lang.Name, cmd, err)
+			if err := run.runCommand(cmd); err != nil {
+				return fmt.Errorf("failed to configure %s with command %v: %w", lang.Name, cmd.Args, err)
 			}
 		}
 	}
 
 	// Run global tools installation
 	for _, cmd := range config.DevTools.GlobalTools {
-		if len(cmd) == 0 {
+		if len(cmd.Args) == 0 {
 			continue
 		}
 
-		if err := exec.Command(cmd[0], cmd[1:]...).Run(); err != nil {
-			return fmt.Errorf("failed to install global tool %v: %w", cmd, err)
+		if err := run.runCommand(cmd); err != nil {
+			return fmt.Errorf("failed to install global tool %v: %w", cmd.Args, err)
 		}
 	}
 
 	// Verify all tools are accessible
 	for _, tool := range config.DevTools.VerifyTools {
-		if _, err := exec.LookPath(tool); err != nil {
+		if err := run.requireTool(tool); err != nil {
 			return fmt.Errorf("%s is not installed or not in PATH: %w", tool, err)
 		}
 	}
@@ -325,43 +372,60 @@ func installDevTools() error {
 }
 
 // restoreDotfiles copies all configuration files
-func restoreDotfiles() error {
-	config, err := LoadConfig()
-	if err != nil {
-		return fmt.Errorf("failed to load config: %w", err)
-	}
-
+func restoreDotfiles(run *stepRun) error {
+	config := run.config
 	if !config.Dotfiles.Install {
 		return nil // Skip if disabled
 	}
 
 	var copiedFiles []string
+	var linkedFiles []string
 	var missingFiles []string
 
-	// Copy configured dotfiles
-	for srcRelPath, destRelPath := range config.Dotfiles.Mappings {
+	// Copy or link configured dotfiles
+	for srcRelPath, mapping := range config.Dotfiles.Mappings {
 		srcPath := filepath.Join(currentDir, srcRelPath)
-		destPath := filepath.Join(homeDir, destRelPath)
+		destPath := filepath.Join(homeDir, mapping.Dest)
 
 		if info, err := os.Stat(srcPath); err == nil {
+			// Directories are linked whole, stow-style
+			if options := mapping.LinkOptions.Resolve(config.Dotfiles.LinkOptions); options.Symlink() {
+				if err := run.linkFile(srcPath, destPath, options); err != nil {
+					return fmt.Errorf("failed to link %s to %s: %w", destPath, srcPath, err)
+				}
+				linkedFiles = append(linkedFiles, srcRelPath)
+				continue
+			}
 			if info.IsDir() {
-				if err := copyDir(srcPath, destPath); err != nil {
+				ignore, err := mappingIgnore(srcPath, mapping, config)
+				if err != nil {
+					return fmt.Errorf("failed to read ignore patterns for %s: %w", srcPath, err)
+				}
+				if err := run.copyDir(srcPath, destPath, ignore); err != nil {
 					return fmt.Errorf("failed to copy directory %s to %s: %w", srcPath, destPath, err)
 				}
 			} else {
-				if err := copyFile(srcPath, destPath); err != nil {
+				if err := run.copyFile(srcPath, destPath); err != nil {
 					return fmt.Errorf("failed to copy file %s to %s: %w", srcPath, destPath, err)
 				}
 			}
 			copiedFiles = append(copiedFiles, srcRelPath)
 		} else {
 			missingFiles = append(missingFiles, srcRelPath)
+			if run.dryRun {
+				run.record(PlannedAction{Kind: ActionNote, Note: fmt.Sprintf("skip %s (source not found)", srcPath)})
+			}
 		}
 	}
 
+	if run.dryRun {
+		return nil
+	}
+
 	// Store dotfiles status for reporting
 	dotfilesStatus = DotfilesStatus{
 		CopiedFiles:    copiedFiles,
+		LinkedFiles:    linkedFiles,
 		MissingFiles:   missingFiles,
 		IsCleanInstall: len(missingFiles) > 0,
 	}
@@ -370,98 +434,85 @@ func restoreDotfiles() error {
 }
 
 // verifyInstallation checks that everything is working
-func verifyInstallation() error {
+func verifyInstallation(run *stepRun) error {
+	config := run.config
 	logger.Println("Starting verification step")
-	config, err := LoadConfig()
-	if err != nil {
-		logger.Printf("Failed to load config for verification: %v", err)
-		return fmt.Errorf("failed to load config: %w", err)
-	}
 
-	var allTools []string
+	// Verify each tool contributed by the enabled steps
+	var failures []string
+	for _, tool := range collectVerifyTools(config) {
+		if err := run.requireTool(tool); err != nil {
+			failures = append(failures, tool)
+		}
+	}
 
-	// Collect tools to verify from enabled sections
+	// Check that the package step's packages are all installed
 	if config.Homebrew.Install {
-		allTools = append(allTools, "brew")
+		if missing, err := verifyPackages(run); err != nil {
+			failures = append(failures, err.Error())
+		} else if len(missing) > 0 {
+			failures = append(failures, "missing packages "+strings.Join(missing, ", "))
+		}
 	}
 
-	if config.Shell.Install {
-		allTools = append(allTools, config.Shell.RequiredTools...)
+	if len(failures) > 0 {
+		return fmt.Errorf("verification failed for: %s", strings.Join(failures, ", "))
 	}
+	return nil
+}
 
-	if config.DevTools.Install {
-		allTools = append(allTools, config.DevTools.VerifyTools...)
+// verifyPackages returns the configured packages the package manager does
+// not have installed. In dry-run mode the check is only recorded.
+func verifyPackages(run *stepRun) ([]string, error) {
+	manager, err := selectPackageManager(run.config)
+	if err != nil {
+		return nil, err
 	}
-
-	// Remove duplicates
-	toolSet := make(map[string]bool)
-	var uniqueTools []string
-	for _, tool := range allTools {
-		if !toolSet[tool] {
-			toolSet[tool] = true
-			uniqueTools = append(uniqueTools, tool)
-		}
+	if run.dryRun {
+		run.record(PlannedAction{Kind: ActionNote, Note: fmt.Sprintf("check that the %s packages are installed", manager.Name())})
+		return nil, nil
 	}
-
-	// Verify each tool
-	var failures []string
-	for _, tool := range uniqueTools {
-		if _, err := exec.LookPath(tool); err != nil {
-			failures = append(failures, tool)
+	packages, err := manager.Packages(run.config)
+	if err != nil {
+		return nil, err
+	}
+	var selected []string
+	for _, name := range packages {
+		if !run.inst.skipPackages[name] {
+			selected = append(selected, name)
 		}
 	}
-
-	if len(failures) > 0 {
-		return fmt.Errorf("verification failed for: %s", strings.Join(failures, ", "))
+	if len(selected) == 0 {
+		return nil, nil
 	}
-
-	// Generate installation report using actually executed steps
-	// Add verify to executed steps since verification always runs
-	actuallyExecuted := append([]string{}, executedSteps...) // Copy the global executed steps
-	actuallyExecuted = append(actuallyExecuted, "verify")    // Add verify since it's running now
-	generateInstallationReport(uniqueTools, actuallyExecuted)
-	return nil
+	return manager.Check(run.ctx, run.config, selected)
 }
 
 // generateReportAfterInstallation creates a report after installation completes
-func generateReportAfterInstallation(executedSteps []string) {
+// or is interrupted, from the outcomes of the steps that ran
+func generateReportAfterInstallation(inst *installation) {
+	config := inst.config
 	logger.Println("Starting report generation after installation")
-	config, err := LoadConfig()
-	if err != nil {
-		logger.Printf("Failed to load config for report: %v", err)
-		return
-	}
 
-	// Get verified tools based on what was executed
+	// Get verified tools based on what completed
+	var executedSteps []string
 	var verifiedTools []string
-	for _, stepID := range executedSteps {
-		switch stepID {
-		case "homebrew":
-			if config.Homebrew.Install {
-				verifiedTools = append(verifiedTools, "brew")
-			}
-		case "shell":
-			if config.Shell.Install {
-				verifiedTools = append(verifiedTools, config.Shell.RequiredTools...)
-			}
-		case "devtools":
-			if config.DevTools.Install {
-				verifiedTools = append(verifiedTools, config.DevTools.VerifyTools...)
-			}
+	for _, outcome := range inst.Outcomes() {
+		if outcome.Status != StatusComplete {
+			continue
+		}
+		executedSteps = append(executedSteps, outcome.StepID)
+		if step, ok := lookupStep(outcome.StepID); ok && step.Enabled(config) {
+			verifiedTools = append(verifiedTools, step.Verify(config)...)
 		}
 	}
 
-	generateInstallationReport(verifiedTools, executedSteps)
+	generateInstallationReport(config, verifiedTools, executedSteps, inst)
 }
 
 // generateInstallationReport creates a dynamic summary of what was actually installed
-func generateInstallationReport(verifiedTools []string, executedSteps []string) {
+func generateInstallationReport(config *InstallConfig, verifiedTools []string, executedSteps []string, inst *installation) {
 	logger.Println("Starting report generation")
-	config, err := LoadConfig()
-	if err != nil {
-		logger.Printf("Failed to load config for report: %v", err)
-		return // Skip report if config fails
-	}
 
 	reportPath := filepath.Join(currentDir, "macdevtui-report.md")
 	logger.Printf("Creating report at: %s", reportPath)
@@ -492,83 +543,84 @@ func generateInstallationReport(verifiedTools []string, executedSteps []string)
 		return false
 	}
 
-	// Add sections based on what was actually executed
-	if config.Homebrew.Install && wasExecuted("homebrew") {
-		report = append(report, []string{
-			"",
-			"## 🍺 Homebrew Packages",
-			"",
-			fmt.Sprintf("- Installed from Brewfile (searched %d locations)", len(config.Homebrew.BrewfilePaths)),
-			"- Run `brew list` to see all installed packages",
-		}...)
-	}
-
-	if config.Terminal.Install && wasExecuted("terminal") {
-		report = append(report, []string{
-			"",
-			"## 💻 Terminal Configuration",
-			"",
-		}...)
-		for src, dest := range config.Terminal.ConfigFiles {
-			report = append(report, fmt.Sprintf("- `%s` → `~/%s`", src, dest))
-		}
-	}
-
-	if config.Shell.Install && wasExecuted("shell") {
-		report = append(report, []string{
-			"",
-			"## 🐚 Shell Setup",
-			"",
-			fmt.Sprintf("- **Required tools:** `%s`", strings.Join(config.Shell.RequiredTools, "`, `")),
-			fmt.Sprintf("- **Shell files:** `%s`", strings.Join(config.Shell.ShellFiles, "`, `")),
-			fmt.Sprintf("- **Theme:** `%s`", config.Shell.ThemeFile),
-			fmt.Sprintf("- **Initialization commands:** %d executed", len(config.Shell.InitCommands)),
-		}...)
-	}
-
-	if config.DevTools.Install && wasExecuted("devtools") {
-		report = append(report, []string{
-			"",
-			"## 🔧 Development Tools",
-			"",
-		}...)
-
-		for _, lang := range config.DevTools.Languages {
-			if lang.Enabled {
-				report = append(report, fmt.Sprintf("- **%s:** configured (%d commands executed)", lang.Name, len(lang.Commands)))
+	// Add sections for the executed steps that contribute one
+	for _, step := range stepRegistry {
+		reporter, ok := step.(stepReporter)
+		if !ok || !step.Enabled(config) || !wasExecuted(step.ID()) {
+			continue
+		}
+		report = append(report, "", reporter.ReportHeading(), "")
+		report = append(report, reporter.Report(config)...)
+	}
+
+	// List steps that did not complete so a partial report says so
+	interrupted := false
+	var summary []string
+	for _, outcome := range inst.Outcomes() {
+		switch outcome.Status {
+		case StatusCancelled:
+			interrupted = true
+			summary = append(summary, fmt.Sprintf("- ⊘ **%s:** interrupted", getStepDisplayName(outcome.StepID)))
+		case StatusSkipped:
+			summary = append(summary, fmt.Sprintf("- ⊝ **%s:** %s", getStepDisplayName(outcome.StepID), outcome.Err))
+		case StatusError:
+			summary = append(summary, fmt.Sprintf("- ✗ **%s:**", getStepDisplayName(outcome.StepID)))
+			for _, line := range strings.Split(outcome.Err.Error(), "\n") {
+				summary = append(summary, fmt.Sprintf("  - `%s`", line))
 			}
 		}
 	}
+	if len(summary) > 0 {
+		report = append(report, "", "## ⚠️ Run Summary", "")
+		report = append(report, summary...)
+	}
 
-	if config.Dotfiles.Install && wasExecuted("dotfiles") {
-		report = append(report, []string{
-			"",
-			"## 📁 Dotfiles",
-			"",
-		}...)
-
-		if dotfilesStatus.IsCleanInstall {
-			report = append(report, "⚠️ **Clean Install** - Some dotfiles were not found")
-			report = append(report, "")
+	// List the files this run replaced and how to get them back
+	if backup := inst.backup; backup != nil {
+		if files := backup.Files(); len(files) > 0 {
+			report = append(report, "", "## 🗄️ Backups", "")
+			report = append(report, fmt.Sprintf("Replaced files were moved to `%s`:", backup.dir()), "")
+			for _, file := range files {
+				report = append(report, fmt.Sprintf("- `%s`", file))
+			}
+			report = append(report, "", fmt.Sprintf("Restore them with `macDevTUI -restore %s` or the **b** key.", backup.ID))
 		}
+	}
 
-		if len(dotfilesStatus.CopiedFiles) > 0 {
-			report = append(report, "### ✅ Copied Files")
-			for _, file := range dotfilesStatus.CopiedFiles {
-				report = append(report, fmt.Sprintf("- `%s` → `~/%s`", file, config.Dotfiles.Mappings[file]))
+	// Say which files each step created or updated; unchanged ones are counted
+	var fileLines []string
+	for _, step := range stepRegistry {
+		files := inst.Files(step.ID())
+		if len(files) == 0 {
+			continue
+		}
+		fileLines = append(fileLines, fmt.Sprintf("- **%s:** %s", getStepDisplayName(step.ID()), summarizeFiles(files)))
+		for _, file := range files {
+			if file.Change != FileUnchanged {
+				fileLines = append(fileLines, fmt.Sprintf("  - %s `%s`", file.Change, file.Path))
 			}
-			report = append(report, "")
 		}
+	}
+	if len(fileLines) > 0 {
+		report = append(report, "", "## 📝 Changed Files", "")
+		report = append(report, fileLines...)
+	}
 
-		if len(dotfilesStatus.MissingFiles) > 0 {
-			report = append(report, "### ❌ Missing Files")
-			for _, file := range dotfilesStatus.MissingFiles {
-				report = append(report, fmt.Sprintf("- `%s` (not found)", file))
-			}
-			report = append(report, "")
+	// List files that could not be copied exactly as they are in the repo
+	if warnings := inst.Warnings(); len(warnings) > 0 {
+		report = append(report, "", "## ⚠️ Warnings", "")
+		for _, warning := range warnings {
+			report = append(report, fmt.Sprintf("- **%s:** %s", getStepDisplayName(warning.StepID), warning.Message))
 		}
 	}
 
+	closing := "✨ **Installation completed successfully!** ✨"
+	if interrupted {
+		closing = "⚠️ **Installation was interrupted - this is a partial report** ⚠️"
+	} else if len(summary) > 0 {
+		closing = "⚠️ **Installation finished with failures - see the run summary** ⚠️"
+	}
+
 	report = append(report, []string{
 		"",
 		"## 🚀 Next Steps",
@@ -585,11 +637,11 @@ func generateInstallationReport(verifiedTools []string, executedSteps []string)
 		"",
 		"---",
 		"",
-		"✨ **Installation completed successfully!** ✨",
+		closing,
 	}...)
 
 	content := strings.Join(report, "\n")
-	err = os.WriteFile(reportPath, []byte(content), 0644)
+	err := os.WriteFile(reportPath, []byte(content), 0644)
 	if err != nil {
 		logger.Printf("Failed to write report: %v", err)
 	} else {
@@ -615,8 +667,11 @@ func getCurrentConfigPath() string {
 }
 
 // Utility functions for file operations
+
+// copyFile copies a regular file, keeping its permission bits, including
+// setuid, setgid and sticky
 func copyFile(src, dest string) error {
-	data, err := os.ReadFile(src)
+	info, err := os.Stat(src)
 	if err != nil {
 		return err
 	}
@@ -626,26 +681,63 @@ func copyFile(src, dest string) error {
 		return err
 	}
 
-	return os.WriteFile(dest, data, 0644)
+	return copyFileMode(src, dest, info.Mode()&preservedModeBits)
 }
 
-func copyDir(src, dest string) error {
-	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		relPath, err := filepath.Rel(src, path)
-		if err != nil {
-			return err
-		}
+// writeFile writes data to dest with the given mode, creating its directory.
+// Like every file copy it goes through replaceFile, so dest is never left
+// half written.
+func writeFile(dest string, data []byte, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	return replaceFile(dest, mode, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
 
-		destPath := filepath.Join(dest, relPath)
+// replaceFile writes a temporary file next to dest with write, gives it the
+// mode and renames it over dest, so readers see the old file or the new one
+func replaceFile(dest string, mode os.FileMode, write func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(dest), "."+filepath.Base(dest)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
 
-		if info.IsDir() {
-			return os.MkdirAll(destPath, info.Mode())
-		}
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dest)
+}
 
-		return copyFile(path, destPath)
-	})
+// fileDigest returns the SHA-256 of the file at path
+func fileDigest(path string) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	file, err := os.Open(path)
+	if err != nil {
+		return sum, err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return sum, err
+	}
+	copy(sum[:], hash.Sum(nil))
+	return sum, nil
 }
+
+// preservedModeBits are the parts of a file mode that copies keep
+const preservedModeBits = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Lock modes for homebrew.lock_mode
+const (
+	LockWarn   = "warn"   // Report packages that differ from the lock
+	LockStrict = "strict" // Fail the step instead
+)
+
+// BrewLock records the versions of the Brewfile's formulae and casks, and
+// its taps, as resolved by a successful install
+type BrewLock struct {
+	Formulae  map[string]string `json:"formulae"`
+	Casks     map[string]string `json:"casks"`
+	Taps      []string          `json:"taps"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+// readBrewLock reads a lock file, returning nil without error when there
+// is none yet
+func readBrewLock(path string) (*BrewLock, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var lock BrewLock
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil, fmt.Errorf("failed to parse lock file %s: %w", path, err)
+	}
+	if lock.Formulae == nil {
+		lock.Formulae = make(map[string]string)
+	}
+	if lock.Casks == nil {
+		lock.Casks = make(map[string]string)
+	}
+	return &lock, nil
+}
+
+// Save writes the lock file
+func (l *BrewLock) Save(path string) error {
+	l.UpdatedAt = time.Now()
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(path, append(data, '\n'), 0644)
+}
+
+// resolveBrewLock looks up the installed versions of the formulae, casks
+// and taps among entries. Entries that are not installed are left out.
+func resolveBrewLock(ctx context.Context, entries []BrewEntry) (*BrewLock, error) {
+	formulae, err := brewOutput(ctx, "list", "--versions", "--formula")
+	if err != nil {
+		return nil, fmt.Errorf("brew list failed: %w", err)
+	}
+	casks, err := brewOutput(ctx, "list", "--versions", "--cask")
+	if err != nil {
+		return nil, fmt.Errorf("brew list failed: %w", err)
+	}
+	taps, err := brewOutput(ctx, "tap")
+	if err != nil {
+		return nil, fmt.Errorf("brew tap failed: %w", err)
+	}
+	return parseBrewLock(entries, formulae, casks, taps), nil
+}
+
+// parseBrewLock builds a lock from the output of brew list --versions for
+// formulae and casks and of brew tap, keeping what entries declare.
+// Formulae and casks are matched by their short name, as brew list prints
+// them; taps by their full name.
+func parseBrewLock(entries []BrewEntry, formulae, casks, taps string) *BrewLock {
+	lock := &BrewLock{Formulae: make(map[string]string), Casks: make(map[string]string), Taps: []string{}}
+	declared := make(map[string]bool)
+	for _, entry := range entries {
+		name := entry.Name
+		if entry.Kind != BrewTap {
+			name = shortBrewName(name)
+		}
+		declared[entry.Kind+" "+name] = true
+	}
+
+	for _, kind := range []struct {
+		kind, output string
+		versions     map[string]string
+	}{{BrewFormula, formulae, lock.Formulae}, {BrewCask, casks, lock.Casks}} {
+		// Each line is a name followed by its installed versions, newest last
+		for _, line := range strings.Split(kind.output, "\n") {
+			fields := strings.Fields(line)
+			if len(fields) >= 2 && declared[kind.kind+" "+fields[0]] {
+				kind.versions[fields[0]] = fields[len(fields)-1]
+			}
+		}
+	}
+
+	for _, tap := range strings.Fields(taps) {
+		if declared[BrewTap+" "+tap] {
+			lock.Taps = append(lock.Taps, tap)
+		}
+	}
+	sort.Strings(lock.Taps)
+	return lock
+}
+
+// Compare lists how current differs from the lock: packages installed at
+// another version than the locked one, and locked taps that are missing.
+// Packages the lock does not know, or that are not installed, are not
+// mismatches.
+func (l *BrewLock) Compare(current *BrewLock) []string {
+	var mismatches []string
+	for _, kind := range []struct {
+		kind             string
+		locked, resolved map[string]string
+	}{{BrewFormula, l.Formulae, current.Formulae}, {BrewCask, l.Casks, current.Casks}} {
+		for name, version := range kind.resolved {
+			if locked, ok := kind.locked[name]; ok && locked != version {
+				mismatches = append(mismatches, fmt.Sprintf("%s %s %s (locked %s)", kind.kind, name, version, locked))
+			}
+		}
+	}
+	for _, tap := range l.Taps {
+		if !containsString(current.Taps, tap) {
+			mismatches = append(mismatches, fmt.Sprintf("tap %s is not tapped (locked)", tap))
+		}
+	}
+	sort.Strings(mismatches)
+	return mismatches
+}
+
+// Add records the packages and taps of current that the lock does not
+// know yet, keeping the locked versions of the rest. It reports whether
+// anything was added.
+func (l *BrewLock) Add(current *BrewLock) bool {
+	added := false
+	for _, kind := range []struct{ locked, resolved map[string]string }{{l.Formulae, current.Formulae}, {l.Casks, current.Casks}} {
+		for name, version := range kind.resolved {
+			if _, ok := kind.locked[name]; !ok {
+				kind.locked[name] = version
+				added = true
+			}
+		}
+	}
+	for _, tap := range current.Taps {
+		if !containsString(l.Taps, tap) {
+			l.Taps = append(l.Taps, tap)
+			added = true
+		}
+	}
+	sort.Strings(l.Taps)
+	return added
+}
+
+// checkBrewLock compares the installed versions of entries with the lock
+// file. Mismatches are warnings, or in strict mode fail the step. Before
+// installing (final unset) only strict mode checks, so a mismatch stops the
+// step before anything changes. After a successful install a missing lock
+// file is written, and packages new to the lock are added to it.
+func checkBrewLock(run *stepRun, entries []BrewEntry, final bool) error {
+	config := run.config.Homebrew
+	if config.LockFile == "" || (!final && config.LockModeOrDefault() != LockStrict) {
+		return nil
+	}
+	path := expandPath(config.LockFile)
+	if run.dryRun {
+		if final {
+			run.record(PlannedAction{Kind: ActionNote, Note: fmt.Sprintf("compare installed versions with %s (%s), recording new packages", path, config.LockModeOrDefault())})
+		}
+		return nil
+	}
+
+	lock, err := readBrewLock(path)
+	if err != nil {
+		return err
+	}
+	if lock == nil && !final {
+		return nil
+	}
+	current, err := resolveBrewLock(run.ctx, entries)
+	if err != nil {
+		return fmt.Errorf("failed to resolve package versions: %w", err)
+	}
+	if lock == nil {
+		if err := current.Save(path); err != nil {
+			return fmt.Errorf("failed to write lock file %s: %w", path, err)
+		}
+		run.output("# recorded package versions in " + path)
+		return nil
+	}
+
+	mismatches := lock.Compare(current)
+	homebrewStatus.LockMismatches = mismatches
+	if len(mismatches) > 0 {
+		if config.LockModeOrDefault() == LockStrict {
+			return fmt.Errorf("installed packages differ from %s: %s", path, strings.Join(mismatches, "; "))
+		}
+		for _, mismatch := range mismatches {
+			run.warn(fmt.Sprintf("%s differs from %s", mismatch, path))
+		}
+	}
+	if final && lock.Add(current) {
+		if err := lock.Save(path); err != nil {
+			return fmt.Errorf("failed to update lock file %s: %w", path, err)
+		}
+		run.output("# recorded new packages in " + path)
+	}
+	return nil
+}
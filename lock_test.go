@@ -0,0 +1,51 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+const lockBrewfile = `tap "cirruslabs/cli"
+brew "git"
+brew "cirruslabs/cli/tart"
+cask "kitty"
+`
+
+// TestParseBrewLock resolves a lock from recorded brew output, keeping only
+// what the Brewfile declares and matching taps by their full name
+func TestParseBrewLock(t *testing.T) {
+	entries := parseBrewfile("Brewfile", lockBrewfile).Entries
+	formulae := "git 2.44.0 2.45.1\ntart 2.10.0\nwget 1.24.5\n"
+	casks := "kitty 0.34.1\nfirefox 125.0\n"
+	taps := "cirruslabs/cli\nhomebrew/cask-fonts\n"
+
+	lock := parseBrewLock(entries, formulae, casks, taps)
+	if want := map[string]string{"git": "2.45.1", "tart": "2.10.0"}; !reflect.DeepEqual(lock.Formulae, want) {
+		t.Errorf("Formulae = %v, want %v", lock.Formulae, want)
+	}
+	if want := map[string]string{"kitty": "0.34.1"}; !reflect.DeepEqual(lock.Casks, want) {
+		t.Errorf("Casks = %v, want %v", lock.Casks, want)
+	}
+	if want := []string{"cirruslabs/cli"}; !reflect.DeepEqual(lock.Taps, want) {
+		t.Errorf("Taps = %v, want %v", lock.Taps, want)
+	}
+}
+
+// TestBrewLockCompare reports changed versions and locked taps that are no
+// longer tapped
+func TestBrewLockCompare(t *testing.T) {
+	entries := parseBrewfile("Brewfile", lockBrewfile).Entries
+	locked := parseBrewLock(entries, "git 2.45.1\ntart 2.10.0\n", "kitty 0.34.1\n", "cirruslabs/cli\n")
+	current := parseBrewLock(entries, "git 2.46.0\ntart 2.10.0\n", "kitty 0.34.1\n", "")
+
+	want := []string{
+		"brew git 2.46.0 (locked 2.45.1)",
+		"tap cirruslabs/cli is not tapped (locked)",
+	}
+	if got := locked.Compare(current); !reflect.DeepEqual(got, want) {
+		t.Errorf("Compare() = %q, want %q", got, want)
+	}
+	if got := locked.Compare(locked); len(got) != 0 {
+		t.Errorf("Compare() of the lock with itself = %q, want none", got)
+	}
+}
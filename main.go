@@ -1,6 +1,9 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
 	"os/signal"
@@ -26,6 +29,20 @@ const (
 	ColemakDH
 )
 
+// DetailView selects what the detail pane shows for the selected step
+type DetailView int
+
+const (
+	DetailOverview DetailView = iota
+	DetailPlan
+	DetailLog
+	DetailBackups
+	DetailDiff
+	DetailHarvest
+	DetailPackages
+	DetailDrift
+)
+
 // Notification represents a popup notification
 type Notification struct {
 	Title   string
@@ -46,6 +63,53 @@ type Model struct {
 	currentMessage  string // What's happening now
 	config          *InstallConfig
 	notification    *Notification // Current notification to show
+	detailView      DetailView
+	detailScroll    int                        // First visible line of scrollable detail content
+	plans           map[string][]PlannedAction // Dry-run plans by step ID
+	cancelInstall   context.CancelFunc         // Stops the running installation
+	confirmCancel   bool                       // Waiting for y/n on cancelling
+	quitAfterCancel bool                       // Quit once the cancelled run has finished
+	resume          *Checkpoint                // Unfinished run that can be resumed
+	backups         []*BackupSet               // Backup sets listed in the backups view
+	confirmRestore  *BackupSet                 // Waiting for y/n on restoring this set
+	diffs           map[string][]FileChange    // Changes to existing files by step ID
+	diffFile        int                        // Selected file in the diff view
+	keepFiles       map[string]bool            // Destinations to leave untouched, from the diff review
+	harvest         []FileChange               // Home-side changes that harvesting would copy into the repo
+	harvestNotes    []string                   // Mappings harvesting leaves alone, and why
+	confirmHarvest  bool                       // Waiting for y/B/n on harvesting
+	brewfile        *Brewfile                  // Brewfile listed in the packages view
+	packageCursor   int                        // Selected entry in the packages view
+	skipPackages    map[string]bool            // Brewfile entries deselected in the packages view, by BrewEntry.Key
+	drift           *Drift                     // Installed packages compared with the Brewfile, nil while checking
+	confirmCleanup  bool                       // Waiting for y/n on removing packages not in the Brewfile
+}
+
+// interruptMsg is sent when the process receives SIGINT or SIGTERM
+type interruptMsg struct{}
+
+// brew can take a while, so the drift check, the cleanup and the Brewfile
+// dump run as commands outside Update and report back with these messages
+
+// driftMsg carries the result of a Brewfile drift check
+type driftMsg struct {
+	drift *Drift
+	err   error
+}
+
+// brewDumpMsg carries the result of recording the installed packages with
+// brew bundle dump after a harvest
+type brewDumpMsg struct {
+	harvested string // What the harvest itself copied
+	path      string
+	err       error
+}
+
+// cleanupMsg carries the result of removing the packages a drift check
+// found installed but not declared
+type cleanupMsg struct {
+	removed []string
+	err     error
 }
 
 // NewModel creates a new application model
@@ -67,6 +131,12 @@ func NewModel() Model {
 		steps = getConfigurableSteps(config)
 	}
 
+	// Offer to resume a run that did not finish last time
+	var resume *Checkpoint
+	if config != nil {
+		resume, notification = checkResume(config, notification)
+	}
+
 	return Model{
 		steps:           steps,
 		selectedStep:    0,
@@ -79,6 +149,49 @@ func NewModel() Model {
 		currentMessage:  "Ready to install",
 		config:          config,
 		notification:    notification,
+		resume:          resume,
+	}
+}
+
+// checkResume loads the checkpoint of an unfinished run and reconciles it
+// with config. It returns the checkpoint when there is work to resume, and
+// the notification to show about it, keeping current if there is nothing
+// to say.
+func checkResume(config *InstallConfig, current *Notification) (*Checkpoint, *Notification) {
+	checkpoint, err := loadCheckpoint()
+	if err != nil || checkpoint == nil {
+		return nil, current
+	}
+
+	changed := checkpoint.Reconcile(config)
+	var changedNames []string
+	for _, id := range changed {
+		changedNames = append(changedNames, getStepDisplayName(id))
+	}
+
+	if !checkpoint.HasProgress() {
+		removeCheckpoint()
+		if len(changed) == 0 || current != nil {
+			return nil, current
+		}
+		return nil, &Notification{
+			Title:   "Checkpoint Discarded",
+			Message: fmt.Sprintf("The configuration changed since the last run (%s), so it cannot be resumed", strings.Join(changedNames, ", ")),
+			Type:    "info",
+		}
+	}
+
+	if current != nil {
+		return checkpoint, current
+	}
+	message := fmt.Sprintf("Previous run did not finish: %s. Press r to resume, Enter to dismiss", checkpoint.Summary())
+	if len(changed) > 0 {
+		message += fmt.Sprintf("\nConfiguration changed for %s; those steps will run again", strings.Join(changedNames, ", "))
+	}
+	return checkpoint, &Notification{
+		Title:   "Resume Previous Run?",
+		Message: message,
+		Type:    "info",
 	}
 }
 
@@ -99,52 +212,75 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return m.handleKeypress(msg)
 
 	case InstallMsg:
-		// Handle installation progress messages
-		if msg.StepID != "" {
-			for i, step := range m.steps {
-				if step.ID == msg.StepID {
-					m.steps[i].Status = msg.Status
-					if msg.Error != nil {
-						m.steps[i].Error = msg.Error.Error()
-					}
-					break
+		m = m.handleInstallMsg(msg)
+		if m.quitAfterCancel && !m.installing {
+			return m, tea.Quit
+		}
+		return m, nil
+
+	case interruptMsg:
+		// Signals skip the confirmation but still let the run clean up
+		if m.installing {
+			m.quitAfterCancel = true
+			m.cancelInstall()
+			m.currentMessage = "Interrupted, stopping current command..."
+			return m, nil
+		}
+		return m, tea.Quit
+
+	case OutputMsg:
+		for i, step := range m.steps {
+			if step.ID == msg.StepID {
+				m.steps[i].Output = append(m.steps[i].Output, msg.Line)
+				if over := len(m.steps[i].Output) - maxStepOutputLines; over > 0 {
+					m.steps[i].Output = m.steps[i].Output[over:]
 				}
+				break
 			}
 		}
+		return m, nil
 
-		// Handle errors by showing notification
-		if msg.Error != nil {
-			m.installing = false
+	case driftMsg:
+		if m.detailView != DetailDrift {
+			return m, nil
+		}
+		if msg.err != nil {
+			m.detailView = DetailOverview
 			m.notification = &Notification{
-				Title:   "Installation Error",
-				Message: fmt.Sprintf("Step '%s' failed: %s\nPress Enter to dismiss", msg.StepID, msg.Error.Error()),
+				Title:   "Drift Check Failed",
+				Message: msg.err.Error(),
 				Type:    "error",
 			}
+			return m, nil
 		}
+		m.drift = msg.drift
+		return m, nil
 
-		// Update progress and message
-		if msg.Progress > 0 {
-			m.currentProgress = msg.Progress
+	case brewDumpMsg:
+		if msg.err != nil {
+			m.notification = &Notification{
+				Title:   "Harvest Incomplete",
+				Message: msg.harvested + ", but " + msg.err.Error(),
+				Type:    "error",
+			}
+			return m, nil
 		}
-		if msg.Message != "" {
-			m.currentMessage = msg.Message
+		m.notification = &Notification{
+			Title:   "Harvest Complete",
+			Message: msg.harvested + "; installed packages recorded in " + msg.path,
+			Type:    "success",
 		}
+		return m, nil
 
-		// Check if installation is complete
-		if strings.Contains(msg.Message, "complete!") {
-			m.installing = false
-			m.currentProgress = 100
-			// Mark all enabled steps as complete
-			for i, step := range m.steps {
-				if step.Enabled {
-					m.steps[i].Status = StatusComplete
-				}
-			}
-			// Show completion notification
-			m.notification = &Notification{
-				Title:   "Installation Complete!",
-				Message: fmt.Sprintf("Report saved to: ./macdevtui-report.md\nPress Enter to dismiss"),
-				Type:    "success",
+	case cleanupMsg:
+		m.notification = cleanupNotification(msg.removed, msg.err)
+		return m, nil
+
+	case FileMsg:
+		for i, step := range m.steps {
+			if step.ID == msg.StepID {
+				m.steps[i].Files = append(m.steps[i].Files, msg.File)
+				break
 			}
 		}
 		return m, nil
@@ -153,24 +289,446 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
+// handleInstallMsg applies an installer progress event to the model
+func (m Model) handleInstallMsg(msg InstallMsg) Model {
+	// Update the step the event refers to
+	if msg.StepID != "" {
+		for i, step := range m.steps {
+			if step.ID != msg.StepID {
+				continue
+			}
+			switch msg.Event {
+			case EventStepStarted:
+				m.steps[i].Status = StatusInProgress
+				m.steps[i].Progress = 0
+				m.steps[i].Error = ""
+				m.steps[i].Files = nil
+				if msg.Attempt <= 1 {
+					m.steps[i].Output = nil
+				}
+			case EventCommandStarted, EventProgress:
+				m.steps[i].Status = StatusInProgress
+				m.steps[i].Progress = msg.StepProgress
+			case EventStepFinished:
+				m.steps[i].Status = msg.Status
+				if msg.Status == StatusComplete {
+					m.steps[i].Progress = 100
+				}
+			}
+			if msg.Error != nil {
+				m.steps[i].Error = msg.Error.Error()
+			}
+			break
+		}
+	}
+
+	// Update progress and message
+	if msg.Progress > 0 {
+		m.currentProgress = msg.Progress
+	}
+	if msg.Message != "" {
+		m.currentMessage = msg.Message
+	}
+
+	if msg.Event != EventInstallFinished {
+		return m
+	}
+
+	m.installing = false
+	m.confirmCancel = false
+	if m.cancelInstall != nil {
+		m.cancelInstall()
+		m.cancelInstall = nil
+	}
+
+	// A run that did not finish leaves a checkpoint that can be resumed
+	if msg.Status != StatusComplete {
+		if checkpoint, err := loadCheckpoint(); err == nil && checkpoint != nil && checkpoint.HasProgress() {
+			m.resume = checkpoint
+		}
+	}
+
+	if msg.Status == StatusCancelled {
+		m.notification = &Notification{
+			Title:   "Installation Cancelled",
+			Message: "Partial report saved to: ./macdevtui-report.md\nPress r to resume later, Enter to dismiss",
+			Type:    "info",
+		}
+		return m
+	}
+
+	// Handle errors by showing notification
+	if msg.Error != nil {
+		// Joined errors put one failure per line; show them all on the banner
+		failures := strings.Split(msg.Error.Error(), "\n")
+		message := fmt.Sprintf("Step '%s' failed: %s", msg.StepID, msg.Error.Error())
+		if len(failures) > 1 {
+			message = fmt.Sprintf("%d failures: %s", len(failures), strings.Join(failures, "; "))
+		}
+		var cmdErr *CommandError
+		if errors.As(msg.Error, &cmdErr) && len(cmdErr.StderrTail) > 0 {
+			message += "\nstderr: " + strings.Join(cmdErr.StderrTail, " ⏎ ")
+		}
+		m.notification = &Notification{
+			Title:   "Installation Error",
+			Message: message + "\nPress o for the step output, Enter to dismiss",
+			Type:    "error",
+		}
+		return m
+	}
+
+	m.currentProgress = 100
+	// Show completion notification
+	m.notification = &Notification{
+		Title:   "Installation Complete!",
+		Message: fmt.Sprintf("Report saved to: ./macdevtui-report.md\nPress Enter to dismiss"),
+		Type:    "success",
+	}
+	return m
+}
+
 // handleKeypress processes keyboard input
 func (m Model) handleKeypress(msg tea.KeyMsg) (Model, tea.Cmd) {
 	key := msg.String()
 
+	// Answer a pending cancel confirmation before anything else
+	if m.confirmCancel {
+		m.confirmCancel = false
+		m.notification = nil
+		if (key == "y" || key == "Y") && m.installing {
+			m.cancelInstall()
+			m.currentMessage = "Cancelling, stopping current command..."
+		}
+		return m, nil
+	}
+
+	// Answer a pending harvest confirmation
+	if m.confirmHarvest {
+		m.confirmHarvest = false
+		m.notification = nil
+		if key == "y" || key == "Y" || key == "B" {
+			var cmd tea.Cmd
+			m.notification, cmd = m.harvestNotification(key == "B")
+			m.detailView = DetailOverview
+			return m, cmd
+		}
+		return m, nil
+	}
+
+	// Answer a pending cleanup confirmation
+	if m.confirmCleanup {
+		m.confirmCleanup = false
+		m.notification = nil
+		if key == "y" || key == "Y" {
+			m.detailView = DetailOverview
+			m.notification = &Notification{
+				Title:   "Cleaning Up",
+				Message: fmt.Sprintf("Uninstalling %d package(s) not in the Brewfile...", len(m.drift.Extra)),
+				Type:    "info",
+			}
+			drift := m.drift
+			return m, func() tea.Msg {
+				removed, err := cleanupDrift(context.Background(), drift)
+				return cleanupMsg{removed: removed, err: err}
+			}
+		}
+		return m, nil
+	}
+
+	// Answer a pending restore confirmation
+	if m.confirmRestore != nil {
+		set := m.confirmRestore
+		m.confirmRestore = nil
+		m.notification = nil
+		if key == "y" || key == "Y" {
+			m.notification = restoreNotification(set)
+		}
+		return m, nil
+	}
+
 	// Handle notification dismissal first
 	if m.notification != nil && (key == "enter" || key == "esc") {
 		m.notification = nil
 		return m, nil
 	}
+	// Allow jumping to the log of a failed step from its error notification
+	if m.notification != nil && m.notification.Title == "Installation Error" && key == "o" {
+		m.notification = nil
+		for i, step := range m.steps {
+			if step.Status == StatusError {
+				m.selectedStep = i
+				break
+			}
+		}
+		m.detailView = DetailLog
+		m.detailScroll = 0
+		return m, nil
+	}
 
 
 	// Global shortcuts
 	switch key {
-	case "q", "esc", "ctrl+c":
+	case "q", "esc", "ctrl+c", "x":
+		// Quitting while installing cancels the run after confirmation
+		if m.installing {
+			m.confirmCancel = true
+			m.notification = &Notification{
+				Title:   "Cancel Installation?",
+				Message: "The current command will be stopped and a partial report written. Press y to cancel, any other key to continue",
+				Type:    "error",
+			}
+			return m, nil
+		}
+		if key == "x" {
+			return m, nil
+		}
 		return m, tea.Quit
 	case "?":
 		m.showHelp = !m.showHelp
 		return m, nil
+	case "p", "P":
+		// Toggle the dry-run plan view
+		if m.installing || m.config == nil {
+			return m, nil
+		}
+		m.detailScroll = 0
+		if m.detailView == DetailPlan {
+			m.detailView = DetailOverview
+			return m, nil
+		}
+		m.detailView = DetailPlan
+		m.plans = make(map[string][]PlannedAction)
+		for _, step := range m.steps {
+			if impl, ok := lookupStep(step.ID); ok {
+				m.plans[step.ID] = impl.Plan(m.config)
+			}
+		}
+		return m, nil
+	case "o", "O":
+		// Toggle the command output log for the selected step
+		m.detailScroll = 0
+		if m.detailView == DetailLog {
+			m.detailView = DetailOverview
+		} else {
+			m.detailView = DetailLog
+		}
+		return m, nil
+	case "pgup":
+		// The log view scrolls back from its newest line
+		if m.detailView == DetailLog {
+			m.detailScroll += 5
+		} else {
+			m.detailScroll -= 5
+		}
+		if m.detailScroll < 0 {
+			m.detailScroll = 0
+		}
+		return m, nil
+	case "pgdown":
+		if m.detailView == DetailLog {
+			m.detailScroll -= 5
+		} else {
+			m.detailScroll += 5
+		}
+		if m.detailScroll < 0 {
+			m.detailScroll = 0
+		}
+		return m, nil
+	case "d", "D":
+		// Toggle the review of changes to existing files
+		if m.installing || m.config == nil {
+			return m, nil
+		}
+		m.detailScroll = 0
+		m.diffFile = 0
+		if m.detailView == DetailDiff {
+			m.detailView = DetailOverview
+			return m, nil
+		}
+		m.detailView = DetailDiff
+		m.diffs = make(map[string][]FileChange)
+		for _, step := range m.steps {
+			if impl, ok := lookupStep(step.ID); ok {
+				m.diffs[step.ID] = previewChanges(impl, m.config)
+			}
+		}
+		return m, nil
+	case "H":
+		// Toggle the review of home-side changes to harvest into the repo;
+		// pressing it again with changes listed asks to harvest them
+		if m.installing || m.config == nil {
+			return m, nil
+		}
+		if m.detailView == DetailHarvest {
+			if len(m.harvest) == 0 {
+				m.detailView = DetailOverview
+				return m, nil
+			}
+			m.confirmHarvest = true
+			m.notification = &Notification{
+				Title:   "Harvest Into Repo?",
+				Message: fmt.Sprintf("%d file(s) marked [apply] will be copied from your home directory into the repo. Press y to harvest, B to also record installed packages with brew bundle dump, any other key to cancel", len(m.harvest)-m.keptHarvestCount()),
+				Type:    "info",
+			}
+			return m, nil
+		}
+		m.detailScroll = 0
+		m.diffFile = 0
+		m.harvest, m.harvestNotes = harvestChanges(m.config)
+		m.detailView = DetailHarvest
+		return m, nil
+	case "i", "I":
+		// Toggle the Brewfile packages of the Homebrew step
+		if m.installing || m.config == nil {
+			return m, nil
+		}
+		m.detailScroll = 0
+		if m.detailView == DetailPackages {
+			m.detailView = DetailOverview
+			return m, nil
+		}
+		brewfile, err := loadBrewfile(m.config)
+		if err != nil {
+			m.notification = &Notification{
+				Title:   "Packages Unavailable",
+				Message: err.Error(),
+				Type:    "info",
+			}
+			return m, nil
+		}
+		m.brewfile = brewfile
+		m.packageCursor = 0
+		for i, step := range m.steps {
+			if step.ID == "homebrew" {
+				m.selectedStep = i
+			}
+		}
+		m.detailView = DetailPackages
+		return m, nil
+	case "C":
+		// Toggle the check of installed packages against the Brewfile;
+		// pressing it again with extra packages listed asks to remove them
+		if m.installing || m.config == nil {
+			return m, nil
+		}
+		if m.detailView == DetailDrift {
+			if m.drift == nil || len(m.drift.Extra) == 0 {
+				m.detailView = DetailOverview
+				return m, nil
+			}
+			m.confirmCleanup = true
+			m.notification = &Notification{
+				Title:   "Remove Extra Packages?",
+				Message: fmt.Sprintf("%d package(s) not in the Brewfile will be uninstalled with brew uninstall. Press y to remove them, any other key to keep them", len(m.drift.Extra)),
+				Type:    "info",
+			}
+			return m, nil
+		}
+		m.detailScroll = 0
+		m.drift = nil
+		m.detailView = DetailDrift
+		config := m.config
+		skip := make(map[string]bool)
+		for key, skipped := range m.skipPackages {
+			skip[key] = skipped
+		}
+		return m, func() tea.Msg {
+			drift, err := checkDrift(context.Background(), config, skip)
+			return driftMsg{drift: drift, err: err}
+		}
+	case "]", "[":
+		// Move between packages in the packages view
+		if m.detailView == DetailPackages && m.brewfile != nil {
+			if key == "]" && m.packageCursor < len(m.brewfile.Entries)-1 {
+				m.packageCursor++
+			} else if key == "[" && m.packageCursor > 0 {
+				m.packageCursor--
+			}
+			return m, nil
+		}
+		// Move between changed files in the diff and harvest views
+		changes := m.reviewedChanges()
+		if changes == nil {
+			return m, nil
+		}
+		count := len(changes)
+		if key == "]" && m.diffFile < count-1 {
+			m.diffFile++
+			m.detailScroll = 0
+		} else if key == "[" && m.diffFile > 0 {
+			m.diffFile--
+			m.detailScroll = 0
+		}
+		return m, nil
+	case "a", "A":
+		// Select or deselect the package under the cursor for the next run
+		if m.detailView == DetailPackages && m.brewfile != nil {
+			entries := m.brewfile.ByKind()
+			if m.installing || m.packageCursor >= len(entries) {
+				return m, nil
+			}
+			key := entries[m.packageCursor].Key()
+			if m.skipPackages == nil {
+				m.skipPackages = make(map[string]bool)
+			}
+			if m.skipPackages[key] {
+				delete(m.skipPackages, key)
+			} else {
+				m.skipPackages[key] = true
+			}
+			return m, nil
+		}
+		// Accept or skip the selected file's change for the next run or harvest
+		changes := m.reviewedChanges()
+		if m.installing || m.diffFile >= len(changes) {
+			return m, nil
+		}
+		dest := changes[m.diffFile].Dest
+		if m.keepFiles == nil {
+			m.keepFiles = make(map[string]bool)
+		}
+		if m.keepFiles[dest] {
+			delete(m.keepFiles, dest)
+		} else {
+			m.keepFiles[dest] = true
+		}
+		return m, nil
+	case "b", "B":
+		// Toggle the list of backups made by earlier runs
+		if m.installing {
+			return m, nil
+		}
+		m.detailScroll = 0
+		if m.detailView == DetailBackups {
+			m.detailView = DetailOverview
+			return m, nil
+		}
+		backups, err := listBackups()
+		if err != nil {
+			m.notification = &Notification{
+				Title:   "Backups Unavailable",
+				Message: fmt.Sprintf("Failed to read %s: %v", backupRoot, err),
+				Type:    "error",
+			}
+			return m, nil
+		}
+		m.backups = backups
+		m.detailView = DetailBackups
+		return m, nil
+	case "1", "2", "3", "4", "5", "6", "7", "8", "9":
+		// Pick a backup set to restore from the backups view
+		index := int(key[0] - '1')
+		if m.detailView != DetailBackups || m.installing || index >= len(m.backups) {
+			return m, nil
+		}
+		set := m.backups[index]
+		m.confirmRestore = set
+		m.notification = &Notification{
+			Title:   "Restore Backup?",
+			Message: fmt.Sprintf("%d file(s) from %s will replace the current ones. Press y to restore, any other key to keep them", len(set.Files()), set.ID),
+			Type:    "info",
+		}
+		return m, nil
 	case "c":
 		// Toggle keyboard layout
 		if m.keyboardLayout == QWERTY {
@@ -182,8 +740,14 @@ func (m Model) handleKeypress(msg tea.KeyMsg) (Model, tea.Cmd) {
 	case "s", "S":
 		// START installation (only if no config errors)
 		if !m.installing && m.notification == nil {
-			m.installing = true
-			return m, m.StartInstallation()
+			return m.startInstall(nil)
+		}
+		return m, nil
+	case "r", "R":
+		// Resume the unfinished run offered at startup
+		if !m.installing && m.resume != nil {
+			m.notification = nil
+			return m.startInstall(m.resume)
 		}
 		return m, nil
 	}
@@ -194,10 +758,14 @@ func (m Model) handleKeypress(msg tea.KeyMsg) (Model, tea.Cmd) {
 		case "up", "k":
 			if m.selectedStep > 0 {
 				m.selectedStep--
+				m.detailScroll = 0
+				m.diffFile = 0
 			}
 		case "down", "j":
 			if m.selectedStep < len(m.steps)-1 {
 				m.selectedStep++
+				m.detailScroll = 0
+				m.diffFile = 0
 			}
 		case "enter", " ":
 			return m.toggleStep()
@@ -207,10 +775,14 @@ func (m Model) handleKeypress(msg tea.KeyMsg) (Model, tea.Cmd) {
 		case "up", "u":
 			if m.selectedStep > 0 {
 				m.selectedStep--
+				m.detailScroll = 0
+				m.diffFile = 0
 			}
 		case "down", "e":
 			if m.selectedStep < len(m.steps)-1 {
 				m.selectedStep++
+				m.detailScroll = 0
+				m.diffFile = 0
 			}
 		case "enter", " ":
 			return m.toggleStep()
@@ -220,6 +792,40 @@ func (m Model) handleKeypress(msg tea.KeyMsg) (Model, tea.Cmd) {
 	return m, nil
 }
 
+// startInstall resets step state and starts an installation, resuming from
+// checkpoint when it is not nil
+func (m Model) startInstall(checkpoint *Checkpoint) (Model, tea.Cmd) {
+	m.installing = true
+	m.currentProgress = 0
+	m.currentMessage = "Starting installation..."
+	for i := range m.steps {
+		m.steps[i].Status = StatusReady
+		m.steps[i].Progress = 0
+		m.steps[i].Error = ""
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancelInstall = cancel
+	m.resume = nil
+	return m, m.StartInstallation(ctx, checkpoint)
+}
+
+// restoreNotification restores a backup set and reports the result
+func restoreNotification(set *BackupSet) *Notification {
+	restored, err := set.Restore()
+	if err != nil {
+		return &Notification{
+			Title:   "Restore Failed",
+			Message: fmt.Sprintf("Restored %d file(s) from %s before failing: %v", len(restored), set.ID, err),
+			Type:    "error",
+		}
+	}
+	return &Notification{
+		Title:   "Backup Restored",
+		Message: fmt.Sprintf("Restored %d file(s) from %s", len(restored), set.ID),
+		Type:    "success",
+	}
+}
+
 // toggleStep toggles the enabled state of the current step
 func (m Model) toggleStep() (Model, tea.Cmd) {
 	if m.selectedStep >= 0 && m.selectedStep < len(m.steps) {
@@ -290,7 +896,7 @@ func (m Model) View() string {
 	navPane := navPaneStyle.Width(navWidth).Height(contentHeight).Render(navContent)
 
 	// Render detail pane
-	detailContent := m.renderDetails(detailWidth)
+	detailContent := m.renderDetails(detailWidth, contentHeight)
 	detailPane := detailPaneStyle.Width(detailWidth).Height(contentHeight).Render(detailContent)
 
 	// Combine panes horizontally
@@ -325,9 +931,13 @@ func (m Model) renderNavigation(height int) string {
 		case StatusComplete:
 			status = " ✓"
 		case StatusInProgress:
-			status = " ◐"
+			status = fmt.Sprintf(" ◐ %d%%", step.Progress)
 		case StatusError:
 			status = " ✗"
+		case StatusCancelled:
+			status = " ⊘"
+		case StatusSkipped:
+			status = " ⊝"
 		}
 
 		text := fmt.Sprintf("%s %s%s", icon, step.Title, status)
@@ -343,7 +953,16 @@ func (m Model) renderNavigation(height int) string {
 }
 
 // renderDetails renders the right detail pane
-func (m Model) renderDetails(paneWidth int) string {
+func (m Model) renderDetails(paneWidth, paneHeight int) string {
+	if m.detailView == DetailBackups {
+		return m.renderBackups(paneWidth, paneHeight)
+	}
+	if m.detailView == DetailHarvest {
+		return m.renderHarvest(paneWidth, paneHeight)
+	}
+	if m.detailView == DetailDrift {
+		return m.renderDrift(paneWidth, paneHeight)
+	}
 	if m.selectedStep < 0 || m.selectedStep >= len(m.steps) {
 		return "Invalid selection"
 	}
@@ -356,10 +975,48 @@ func (m Model) renderDetails(paneWidth int) string {
 	// Description
 	description := step.Description
 
-	// Items list
+	// Items list, or the dry-run plan when in plan view
 	var itemsList []string
-	for _, item := range step.Items {
-		itemsList = append(itemsList, "• "+item)
+	if m.detailView == DetailPlan {
+		description = "Dry run: actions this step would take (nothing is changed)"
+		var planLines []string
+		for _, action := range m.plans[step.ID] {
+			planLines = append(planLines, "• "+action.String())
+		}
+		if len(planLines) == 0 {
+			planLines = []string{"Nothing to do"}
+		}
+		itemsList = scrollWindow(planLines, m.detailScroll, paneHeight-18)
+	} else if m.detailView == DetailDiff {
+		description = "Changes to existing files: ]/[ to pick a file, a to accept or skip it"
+		itemsList = scrollWindow(m.diffLines(step.ID), m.detailScroll, paneHeight-18)
+	} else if m.detailView == DetailPackages && step.ID == "homebrew" {
+		description = "Brewfile packages: ]/[ to pick one, a to select or deselect it"
+		lines, cursorLine := m.packageLines()
+		// Keep the cursor in view, then apply any manual scrolling
+		start := max(cursorLine-(paneHeight-18)+1, 0) + m.detailScroll
+		itemsList = scrollWindow(lines, start, paneHeight-18)
+	} else if m.detailView == DetailLog {
+		description = "Command output from the last run"
+		logLines := step.Output
+		if len(logLines) == 0 {
+			logLines = []string{"No output captured yet"}
+		}
+		// Log offsets count back from the newest line so the view follows output
+		offset := len(logLines) - (paneHeight - 18) - m.detailScroll
+		itemsList = scrollWindow(logLines, offset+1, paneHeight-18)
+	} else {
+		for _, item := range step.Items {
+			itemsList = append(itemsList, "• "+item)
+		}
+		if len(step.Files) > 0 {
+			itemsList = append(itemsList, "", "Files: "+summarizeFiles(step.Files))
+			for _, file := range step.Files {
+				if file.Change != FileUnchanged {
+					itemsList = append(itemsList, "  "+file.String())
+				}
+			}
+		}
 	}
 	itemsContent := strings.Join(itemsList, "\n")
 	// Make the box responsive to available width
@@ -376,6 +1033,8 @@ func (m Model) renderDetails(paneWidth int) string {
 		statusText = statusCompleteStyle.Render(statusText)
 	} else if step.Status == StatusError {
 		statusText = statusErrorStyle.Render(statusText)
+	} else if step.Status == StatusCancelled || step.Status == StatusSkipped {
+		statusText = statusCancelledStyle.Render(statusText)
 	}
 
 	timeText := fmt.Sprintf("Est. time: %s", FormatEstimatedTime(step.EstTime))
@@ -406,6 +1065,241 @@ func (m Model) renderDetails(paneWidth int) string {
 	return strings.Join(sections, "\n\n")
 }
 
+// reviewedChanges returns the changes listed by the diff or harvest view,
+// or nil in other views
+func (m Model) reviewedChanges() []FileChange {
+	switch {
+	case m.detailView == DetailHarvest:
+		return m.harvest
+	case m.detailView == DetailDiff && len(m.steps) > 0:
+		return m.diffs[m.steps[m.selectedStep].ID]
+	}
+	return nil
+}
+
+// packageLines lists the Brewfile's packages grouped by kind, marking the
+// selected one and whether each will be installed. It also returns the
+// line of the selected package.
+func (m Model) packageLines() ([]string, int) {
+	if m.brewfile == nil || len(m.brewfile.Entries) == 0 {
+		return []string{"No packages in the Brewfile"}, 0
+	}
+	lines := []string{"From " + m.brewfile.Path}
+	cursorLine := 0
+	kind := ""
+	for i, entry := range m.brewfile.ByKind() {
+		if entry.Kind != kind {
+			kind = entry.Kind
+			lines = append(lines, "", brewKindTitle(kind))
+		}
+		marker := "  "
+		if i == m.packageCursor {
+			marker = "▶ "
+			cursorLine = len(lines)
+		}
+		selection := statusCompleteStyle.Render("[x]")
+		if m.skipPackages[entry.Key()] {
+			selection = statusCancelledStyle.Render("[ ]")
+		}
+		line := fmt.Sprintf("%s%s %s", marker, selection, entry)
+		if len(m.brewfile.Sources) > 1 {
+			line += "  ← " + entry.Source
+		}
+		lines = append(lines, line)
+	}
+	if skipped := m.brewfile.Skipped(m.skipPackages); skipped > 0 {
+		lines = append(lines, "", fmt.Sprintf("%d package(s) deselected; the rest are installed from a generated Brewfile", skipped))
+	}
+	return lines, cursorLine
+}
+
+// keptHarvestCount counts the harvest changes marked to skip
+func (m Model) keptHarvestCount() int {
+	kept := 0
+	for _, change := range m.harvest {
+		if m.keepFiles[change.Dest] {
+			kept++
+		}
+	}
+	return kept
+}
+
+// harvestNotification copies the reviewed home-side changes into the repo
+// and reports the result. With brewDump it also returns a command that
+// dumps the Brewfile, which reports back with a brewDumpMsg.
+func (m Model) harvestNotification(brewDump bool) (*Notification, tea.Cmd) {
+	harvested, err := applyHarvest(m.harvest, m.keepFiles)
+	if err != nil {
+		return &Notification{
+			Title:   "Harvest Failed",
+			Message: fmt.Sprintf("Harvested %d file(s) before failing: %v", len(harvested), err),
+			Type:    "error",
+		}, nil
+	}
+	message := fmt.Sprintf("Copied %d file(s) into %s", len(harvested), currentDir)
+	if !brewDump {
+		return &Notification{Title: "Harvest Complete", Message: message, Type: "success"}, nil
+	}
+
+	config := m.config
+	dump := func() tea.Msg {
+		path, err := dumpBrewfile(config)
+		return brewDumpMsg{harvested: message, path: path, err: err}
+	}
+	return &Notification{
+		Title:   "Harvesting",
+		Message: message + "; recording installed packages with brew bundle dump...",
+		Type:    "info",
+	}, dump
+}
+
+// cleanupNotification reports the result of uninstalling the packages the
+// drift check found installed but not declared
+func cleanupNotification(removed []string, err error) *Notification {
+	if err != nil {
+		return &Notification{
+			Title:   "Cleanup Failed",
+			Message: fmt.Sprintf("Removed %d package(s) before failing: %v", len(removed), err),
+			Type:    "error",
+		}
+	}
+	return &Notification{
+		Title:   "Cleanup Complete",
+		Message: fmt.Sprintf("Removed %d package(s) not in the Brewfile: %s", len(removed), strings.Join(removed, ", ")),
+		Type:    "success",
+	}
+}
+
+// diffLines lists a step's changed files, marking the selected one and the
+// decision on each, followed by the diff of the selected file
+func (m Model) diffLines(stepID string) []string {
+	if len(m.diffs[stepID]) == 0 {
+		return []string{"No existing files would change"}
+	}
+	return m.changeLines(m.diffs[stepID])
+}
+
+// changeLines lists changed files, marking the selected one and the
+// decision on each, followed by the diff of the selected file
+func (m Model) changeLines(changes []FileChange) []string {
+	var lines []string
+	for i, change := range changes {
+		marker := "  "
+		if i == m.diffFile {
+			marker = "▶ "
+		}
+		decision := statusCompleteStyle.Render("[apply]")
+		if m.keepFiles[change.Dest] {
+			decision = statusCancelledStyle.Render("[skip] ")
+		}
+		lines = append(lines, fmt.Sprintf("%s%s %s  %s", marker, decision, change.Dest, change.Summary))
+	}
+	lines = append(lines, "")
+
+	change := changes[min(m.diffFile, len(changes)-1)]
+	if len(change.Diff) == 0 {
+		return append(lines, change.Summary)
+	}
+	for _, line := range change.Diff {
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+			lines = append(lines, line)
+		case strings.HasPrefix(line, "@@"):
+			lines = append(lines, diffHunkStyle.Render(line))
+		case strings.HasPrefix(line, "+"):
+			lines = append(lines, diffAddStyle.Render(line))
+		case strings.HasPrefix(line, "-"):
+			lines = append(lines, diffRemoveStyle.Render(line))
+		default:
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// renderHarvest renders the home-side changes harvesting would copy into
+// the repo, with the diff of the selected file
+func (m Model) renderHarvest(paneWidth, paneHeight int) string {
+	title := detailTitleStyle.Render("🌾 Harvest")
+	description := "Home-side changes to copy back into the repo: ]/[ to pick a file, a to accept or skip it, H again to harvest"
+
+	var lines []string
+	for _, note := range m.harvestNotes {
+		lines = append(lines, "note: "+note)
+	}
+	if len(m.harvestNotes) > 0 {
+		lines = append(lines, "")
+	}
+	if len(m.harvest) == 0 {
+		lines = append(lines, "Repo is up to date with the home directory")
+	} else {
+		lines = append(lines, m.changeLines(m.harvest)...)
+	}
+
+	box := detailBoxStyle.Width(paneWidth - 8).Render(strings.Join(scrollWindow(lines, m.detailScroll, paneHeight-12), "\n"))
+	return strings.Join([]string{title, description, box}, "\n\n")
+}
+
+// renderDrift renders how the installed packages differ from the Brewfile
+func (m Model) renderDrift(paneWidth, paneHeight int) string {
+	title := detailTitleStyle.Render("🧭 Drift")
+	description := "Installed packages compared with the Brewfile (read-only): C again to remove packages not in the Brewfile"
+
+	lines := []string{"Checking installed packages with brew bundle check, brew list and brew outdated..."}
+	if m.drift != nil {
+		lines = m.drift.Lines()
+	}
+
+	box := detailBoxStyle.Width(paneWidth - 8).Render(strings.Join(scrollWindow(lines, m.detailScroll, paneHeight-12), "\n"))
+	return strings.Join([]string{title, description, box}, "\n\n")
+}
+
+// renderBackups renders the backup sets in the detail pane, numbered for
+// selection with the digit keys
+func (m Model) renderBackups(paneWidth, paneHeight int) string {
+	title := detailTitleStyle.Render("🗄️ Backups")
+	description := fmt.Sprintf("Files replaced by earlier runs, kept in %s. Press 1-9 to restore a set", backupRoot)
+
+	var lines []string
+	for i, set := range m.backups {
+		marker := fmt.Sprintf("%d)", i+1)
+		if i >= 9 {
+			marker = "  " // Only the newest nine can be picked with a key
+		}
+		lines = append(lines, fmt.Sprintf("%s %s", marker, set.Summary()))
+		for _, file := range set.Files() {
+			lines = append(lines, "     "+file)
+		}
+	}
+	if len(lines) == 0 {
+		lines = []string{"No backups yet"}
+	}
+
+	box := detailBoxStyle.Width(paneWidth - 8).Render(strings.Join(scrollWindow(lines, m.detailScroll, paneHeight-12), "\n"))
+	return strings.Join([]string{title, description, box}, "\n\n")
+}
+
+// scrollWindow returns the visible part of lines starting at offset, with a
+// position hint appended when the content does not fit
+func scrollWindow(lines []string, offset, height int) []string {
+	if height < 3 {
+		height = 3
+	}
+	if len(lines) <= height {
+		return lines
+	}
+	height-- // Leave room for the position hint
+	if offset > len(lines)-height {
+		offset = len(lines) - height
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	visible := append([]string{}, lines[offset:offset+height]...)
+	hint := fmt.Sprintf("── lines %d-%d of %d (PgUp/PgDn to scroll) ──", offset+1, offset+height, len(lines))
+	return append(visible, hint)
+}
+
 // renderFooter renders the bottom instruction bar
 func (m Model) renderFooter() string {
 	layout := "QWERTY"
@@ -420,15 +1314,15 @@ func (m Model) renderFooter() string {
 	// Skip overflow check in footer to avoid recursion
 	
 	if m.installing {
-		keys = "Installation in progress... • q: Quit"
+		keys = "Installation in progress... • o: Output • PgUp/PgDn: Scroll • x/q: Cancel"
 	} else if m.notification != nil && m.notification.Type == "error" {
 		keys = "Configuration error - Installation disabled • q: Quit"
 	} else if contentOverflows {
 		keys = "↑/↓: Scroll • j/k: Navigate steps • Space: Toggle • S: START • q: Quit"
 	} else if m.keyboardLayout == QWERTY {
-		keys = "↑/↓ or k/j: Navigate • Space: Toggle • S: START • c: Layout • ?: Help • q: Quit"
+		keys = "↑/↓ or k/j: Navigate • Space: Toggle • S: START • p: Plan • d: Diff • i: Packages • C: Drift • o: Output • b: Backups • c: Layout • ?: Help • q: Quit"
 	} else {
-		keys = "↑/↓ or u/e: Navigate • Space: Toggle • S: START • c: Layout • ?: Help • q: Quit"
+		keys = "↑/↓ or u/e: Navigate • Space: Toggle • S: START • p: Plan • d: Diff • i: Packages • C: Drift • o: Output • b: Backups • c: Layout • ?: Help • q: Quit"
 	}
 
 	footerText := fmt.Sprintf("%s | %s", layout, keys)
@@ -483,6 +1377,16 @@ func (m Model) renderHelp() string {
 		"",
 		"Additional Commands:",
 		"  c: Toggle keyboard layout",
+		"  p: Show/hide the dry-run plan for each step",
+		"  o: Show/hide the command output of the selected step",
+		"  x: Cancel a running installation (asks for confirmation)",
+		"  r: Resume an unfinished run, skipping work it already completed",
+		"  b: Show/hide backups of replaced files; 1-9 restores a set",
+		"  d: Review changes to existing files; ]/[ picks a file, a accepts or skips it",
+		"  H: Review home-side changes to harvest into the repo; H again harvests them",
+		"  i: Show/hide Brewfile packages; ]/[ picks one, a selects or deselects it",
+		"  C: Check installed packages against the Brewfile; C again removes the extra ones",
+		"  PgUp/PgDn: Scroll the detail pane",
 		"  ?: Show/hide this help",
 		"",
 		"Steps:",
@@ -522,16 +1426,76 @@ func (m Model) renderProgressBar(width int) string {
 }
 
 func main() {
+	dryRun := flag.Bool("dry-run", false, "print every action the installer would take and exit")
+	listBackupsFlag := flag.Bool("list-backups", false, "list the backups of replaced files and exit")
+	restore := flag.String("restore", "", "restore the backup set with this ID, or \"latest\", and exit")
+	harvest := flag.Bool("harvest", false, "copy the home-side versions of mapped files back into the repo and exit (with -dry-run, only show the changes)")
+	brewDump := flag.Bool("brew-dump", false, "with -harvest, also record installed packages with brew bundle dump")
+	brewCheck := flag.Bool("brew-check", false, "show Homebrew packages that are missing, not in the Brewfile or outdated, and exit")
+	brewCleanup := flag.Bool("brew-cleanup", false, "with -brew-check, offer to uninstall packages that are not in the Brewfile")
+	uninstall := flag.Bool("uninstall", false, "remove the managed blocks from shell files and exit (with -dry-run, only list them)")
+	flag.Parse()
+
+	if *brewCheck {
+		if err := runDriftCheck(*brewCleanup); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *uninstall {
+		if err := runUninstall(*dryRun); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *harvest {
+		if err := runHarvest(*dryRun, *brewDump); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *listBackupsFlag {
+		if err := printBackups(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *restore != "" {
+		if err := restoreBackupCLI(*restore); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *dryRun {
+		if err := printPlan(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Set up signal handling for graceful shutdown
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 
 	p := tea.NewProgram(NewModel(), tea.WithAltScreen())
+	program = p
 	
-	// Handle signals in a goroutine
+	// Handle signals in a goroutine, letting a running installation
+	// stop its commands and write a partial report before quitting
 	go func() {
 		<-c
-		p.Quit()
+		p.Send(interruptMsg{})
 	}()
 
 	if _, err := p.Run(); err != nil {
@@ -2,7 +2,6 @@ package main
 
 import (
 	"fmt"
-	"strings"
 	"time"
 )
 
@@ -14,6 +13,8 @@ const (
 	StatusInProgress
 	StatusComplete
 	StatusError
+	StatusCancelled
+	StatusSkipped
 )
 
 func (s InstallStatus) String() string {
@@ -26,6 +27,10 @@ func (s InstallStatus) String() string {
 		return "✓ Complete"
 	case StatusError:
 		return "✗ Error"
+	case StatusCancelled:
+		return "⊘ Interrupted"
+	case StatusSkipped:
+		return "⊝ Skipped"
 	default:
 		return "Unknown"
 	}
@@ -43,158 +48,44 @@ type SetupStep struct {
 	Error       string
 	Progress    int // 0-100
 	Enabled     bool
+	Output      []string     // Captured command output from the last run
+	Files       []fileResult // What the last run did to each file it wrote
 }
 
-// getConfigurableSteps creates steps based on loaded configuration
+// maxStepOutputLines caps how much command output is kept per step
+const maxStepOutputLines = 5000
+
+// getConfigurableSteps creates steps from the registry based on loaded configuration
 func getConfigurableSteps(config *InstallConfig) []SetupStep {
 	if config == nil {
 		return []SetupStep{} // Return empty steps if no config
 	}
-	
+
 	var steps []SetupStep
-	
-	// Homebrew step
-	if config.Homebrew.Install {
-		steps = append(steps, SetupStep{
-			ID:          "homebrew",
-			Title:       "Homebrew & Packages",
-			Icon:        "▶",
-			Description: "Install Homebrew and packages from Brewfile",
-			Items:       []string{
-				"Homebrew package manager",
-				fmt.Sprintf("Brewfile locations: %d paths configured", len(config.Homebrew.BrewfilePaths)),
-				"Packages from: " + strings.Join(config.Homebrew.BrewfilePaths, ", "),
-			},
-			EstTime: 15 * time.Minute,
-			Status:  StatusReady,
-			Enabled: true,
-		})
-	}
-	
-	// Terminal step
-	if config.Terminal.Install {
-		var terminalFiles []string
-		for src, dest := range config.Terminal.ConfigFiles {
-			terminalFiles = append(terminalFiles, fmt.Sprintf("%s → %s", src, dest))
-		}
-		
-		steps = append(steps, SetupStep{
-			ID:          "terminal",
-			Title:       "Terminal Configuration", 
-			Icon:        "▶",
-			Description: "Configure terminal applications with Catppuccin theme",
-			Items:       terminalFiles,
-			EstTime:     2 * time.Minute,
-			Status:      StatusReady,
-			Enabled:     true,
-		})
-	}
-	
-	// Shell step
-	if config.Shell.Install {
-		shellItems := []string{
-			fmt.Sprintf("Required tools: %s", strings.Join(config.Shell.RequiredTools, ", ")),
-			fmt.Sprintf("Shell files: %s", strings.Join(config.Shell.ShellFiles, ", ")),
-			fmt.Sprintf("Theme file: %s", config.Shell.ThemeFile),
-			fmt.Sprintf("Init commands: %d configured", len(config.Shell.InitCommands)),
-		}
-		
-		steps = append(steps, SetupStep{
-			ID:          "shell",
-			Title:       "Shell & Prompt Setup",
-			Icon:        "▶", 
-			Description: "Configure Zsh with Oh-My-Posh and productivity tools",
-			Items:       shellItems,
-			EstTime:     3 * time.Minute,
-			Status:      StatusReady,
-			Enabled:     true,
-		})
-	}
-	
-	// DevTools step
-	if config.DevTools.Install {
-		var devItems []string
-		for _, lang := range config.DevTools.Languages {
-			status := "disabled"
-			if lang.Enabled {
-				status = "enabled"
-			}
-			devItems = append(devItems, fmt.Sprintf("%s: %s (%d commands)", lang.Name, status, len(lang.Commands)))
-		}
-		devItems = append(devItems, fmt.Sprintf("Verify tools: %s", strings.Join(config.DevTools.VerifyTools, ", ")))
-		
-		steps = append(steps, SetupStep{
-			ID:          "devtools",
-			Title:       "Development Tools",
-			Icon:        "▶",
-			Description: "Configure development environments and toolchains", 
-			Items:       devItems,
-			EstTime:     5 * time.Minute,
-			Status:      StatusReady,
-			Enabled:     true,
-		})
-	}
-	
-	// Dotfiles step
-	if config.Dotfiles.Install {
-		var dotfileItems []string
-		for src, dest := range config.Dotfiles.Mappings {
-			dotfileItems = append(dotfileItems, fmt.Sprintf("%s → %s", src, dest))
+	for _, step := range stepRegistry {
+		if step.Enabled(config) {
+			steps = append(steps, step.Describe(config))
 		}
-		
-		steps = append(steps, SetupStep{
-			ID:          "dotfiles",
-			Title:       "Restore Dotfiles",
-			Icon:        "▶",
-			Description: "Copy configuration files to their destinations",
-			Items:       dotfileItems,
-			EstTime:     1 * time.Minute,
-			Status:      StatusReady,
-			Enabled:     true,
-		})
 	}
-	
-	// Always add verify step
-	steps = append(steps, SetupStep{
-		ID:          "verify",
-		Title:       "Verify Installation",
-		Icon:        "▶",
-		Description: "Test that all tools are properly installed and accessible",
-		Items:       []string{
-			"Check tool availability in PATH",
-			"Validate configurations", 
-			"Generate installation report",
-		},
-		EstTime: 2 * time.Minute,
-		Status:  StatusReady,
-		Enabled: true,
-	})
-	
 	return steps
 }
 
 // Helper functions for configuration and reporting
 func getTotalConfiguredSteps(config *InstallConfig) int {
 	count := 0
-	if config.Homebrew.Install { count++ }
-	if config.Terminal.Install { count++ }
-	if config.Shell.Install { count++ }
-	if config.DevTools.Install { count++ }
-	if config.Dotfiles.Install { count++ }
-	count++ // Always include verify step
+	for _, step := range stepRegistry {
+		if step.Enabled(config) {
+			count++
+		}
+	}
 	return count
 }
 
 func getStepDisplayName(stepID string) string {
-	switch stepID {
-	case "homebrew": return "Homebrew & Packages"
-	case "terminal": return "Terminal Configuration" 
-	case "shell": return "Shell & Prompt Setup"
-	case "devtools": return "Development Tools"
-	case "dotfiles": return "Restore Dotfiles"
-	case "verify": return "Verify Installation"
-	default: return stepID
+	if step, ok := lookupStep(stepID); ok {
+		return step.Title()
 	}
+	return stepID
 }
 
 // KeyBinding represents keyboard shortcuts
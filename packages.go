@@ -0,0 +1,499 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// PackageManager installs the packages of the package step with one system
+// package manager. Homebrew reads its packages from Brewfiles; the Linux
+// backends each take a list from the packages section of the config.
+type PackageManager interface {
+	// Name returns the identifier used in the config, such as "apt"
+	Name() string
+	// Tool returns the executable that must be in PATH afterwards
+	Tool() string
+	// Bootstrap makes the package manager itself available
+	Bootstrap(run *stepRun) error
+	// Packages returns the configured package list
+	Packages(config *InstallConfig) ([]string, error)
+	// Install installs the given packages from the list
+	Install(run *stepRun, packages []string) error
+	// Check returns the packages in the list that are not installed
+	Check(ctx context.Context, config *InstallConfig, packages []string) ([]string, error)
+	// Installed lists the installed packages
+	Installed(ctx context.Context) ([]string, error)
+}
+
+// Package manager names for packages.manager
+const (
+	ManagerAuto     = "auto"
+	ManagerHomebrew = "homebrew"
+	ManagerApt      = "apt"
+	ManagerDnf      = "dnf"
+	ManagerPacman   = "pacman"
+	ManagerNix      = "nix"
+)
+
+// packageManagers holds every backend by name
+var packageManagers = map[string]PackageManager{
+	ManagerHomebrew: homebrewManager{},
+	ManagerApt: systemManager{
+		name:      ManagerApt,
+		tool:      "apt-get",
+		env:       []string{"DEBIAN_FRONTEND=noninteractive"},
+		refresh:   []string{"apt-get", "update"},
+		install:   []string{"apt-get", "install", "-y"},
+		installed: []string{"dpkg-query", "-W", "-f=${db:Status-Abbrev} ${Package}\n"},
+		parse: func(line string) string {
+			// Only "ii" packages are fully installed
+			if fields := strings.Fields(line); len(fields) == 2 && fields[0] == "ii" {
+				return fields[1]
+			}
+			return ""
+		},
+		list: func(config *InstallConfig) []string { return config.Packages.Apt },
+	},
+	ManagerDnf: systemManager{
+		name:      ManagerDnf,
+		tool:      "dnf",
+		install:   []string{"dnf", "install", "-y"},
+		installed: []string{"rpm", "-qa", "--qf", "%{NAME}\n"},
+		groups:    "@",
+		list:      func(config *InstallConfig) []string { return config.Packages.Dnf },
+	},
+	ManagerPacman: systemManager{
+		name:      ManagerPacman,
+		tool:      "pacman",
+		install:   []string{"pacman", "-S", "--needed", "--noconfirm"},
+		installed: []string{"pacman", "-Qq"},
+		list:      func(config *InstallConfig) []string { return config.Packages.Pacman },
+	},
+	ManagerNix: nixManager{},
+}
+
+// osReleaseManagers maps IDs from /etc/os-release to their package manager
+var osReleaseManagers = map[string]string{
+	"debian":      ManagerApt,
+	"ubuntu":      ManagerApt,
+	"fedora":      ManagerDnf,
+	"rhel":        ManagerDnf,
+	"centos":      ManagerDnf,
+	"arch":        ManagerPacman,
+	"manjaro":     ManagerPacman,
+	"endeavouros": ManagerPacman,
+	"nixos":       ManagerNix,
+}
+
+// packageManagerName returns the backend the config asks for, detecting
+// it from the OS when packages.manager is auto or unset: Homebrew on macOS,
+// and on Linux the distribution's own package manager, falling back to the
+// first one found in PATH and then to Homebrew
+func packageManagerName(config *InstallConfig) string {
+	if name := config.Packages.Manager; name != "" && name != ManagerAuto {
+		return name
+	}
+	if runtime.GOOS != "linux" {
+		return ManagerHomebrew
+	}
+	for _, id := range osReleaseIDs("/etc/os-release") {
+		if name, ok := osReleaseManagers[id]; ok {
+			return name
+		}
+	}
+	for _, name := range []string{ManagerApt, ManagerDnf, ManagerPacman, ManagerNix} {
+		if _, err := exec.LookPath(packageManagers[name].Tool()); err == nil {
+			return name
+		}
+	}
+	return ManagerHomebrew
+}
+
+// selectPackageManager returns the backend the package step uses
+func selectPackageManager(config *InstallConfig) (PackageManager, error) {
+	name := packageManagerName(config)
+	manager, ok := packageManagers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown package manager %q", name)
+	}
+	return manager, nil
+}
+
+// osReleaseIDs returns the ID and ID_LIKE values of an os-release file,
+// most specific first
+func osReleaseIDs(path string) []string {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	var id, like []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		value = strings.Trim(value, `"'`)
+		switch key {
+		case "ID":
+			id = []string{value}
+		case "ID_LIKE":
+			like = strings.Fields(value)
+		}
+	}
+	return append(id, like...)
+}
+
+// missingPackages returns the packages that are not in installed
+func missingPackages(packages, installed []string) []string {
+	have := make(map[string]bool)
+	for _, name := range installed {
+		have[name] = true
+	}
+	var missing []string
+	for _, name := range packages {
+		if !have[name] {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// homebrewManager installs packages from the Brewfiles with brew bundle.
+// Its packages are Brewfile entry keys such as "brew neovim".
+type homebrewManager struct{}
+
+func (homebrewManager) Name() string { return ManagerHomebrew }
+func (homebrewManager) Tool() string { return "brew" }
+
+func (homebrewManager) Bootstrap(run *stepRun) error { return bootstrapHomebrew(run) }
+
+func (homebrewManager) Packages(config *InstallConfig) ([]string, error) {
+	brewfile, err := loadBrewfile(config)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, entry := range brewfile.Entries {
+		keys = append(keys, entry.Key())
+	}
+	return keys, nil
+}
+
+// Install runs brew bundle on the Brewfile, or on a generated one when
+// Brewfiles are merged or some entries are left out, either by packages
+// or by being deselected in the TUI
+func (homebrewManager) Install(run *stepRun, packages []string) error {
+	brewfile, err := loadBrewfile(run.config)
+	if err != nil {
+		return err
+	}
+	entries := brewEntries(brewfile, packages, run.inst.skipPackages)
+	brewfilePath := brewfile.Path
+	generated := ""
+
+	if left := len(brewfile.Entries) - len(entries); left > 0 || len(brewfile.Sources) > 1 {
+		description := fmt.Sprintf("%d deselected package(s) left out", left)
+		if len(brewfile.Sources) > 1 {
+			description = fmt.Sprintf("merged from %d Brewfiles, %s", len(brewfile.Sources), description)
+		}
+		if run.dryRun {
+			run.record(PlannedAction{Kind: ActionNote, Note: "generated Brewfile: " + description})
+			return run.runCommand(Command{Args: []string{"brew", "bundle", "--file=<generated Brewfile>"}})
+		}
+		path, err := writeTempBrewfile(brewfile, entries)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(path)
+		run.output(fmt.Sprintf("# installing from %s: %s", brewfile.Path, description))
+		brewfilePath = path
+		generated = "sha256:" + brewfile.Digest(entries)
+	}
+
+	// With a lock file, installed packages are compared with it first and
+	// kept at their versions rather than upgraded
+	args := []string{"brew", "bundle", "--file=" + brewfilePath}
+	if lockFile := run.config.Homebrew.LockFile; lockFile != "" {
+		if _, err := os.Stat(expandPath(lockFile)); err == nil {
+			args = append(args, "--no-upgrade")
+		}
+		if err := checkBrewLock(run, entries, false); err != nil {
+			return err
+		}
+	}
+
+	// Follow brew bundle's output so progress advances package by package
+	tracker := newBundleTracker(len(entries))
+	run.watch = func(line string) {
+		if name, action, ok := tracker.Feed(line); ok {
+			run.progress(tracker.Fraction(), fmt.Sprintf("Homebrew: %s %s (%d/%d)", action, name, tracker.Done(), tracker.total))
+		}
+	}
+	cmd := Command{Args: args}
+	if generated != "" {
+		// The generated Brewfile's path differs on every run, so a resumed
+		// run recognises the command by the file's content instead
+		cmd.Key = commandKey(append([]string{"brew", "bundle", "--file=" + generated}, args[3:]...))
+	}
+	err = run.runCommand(cmd)
+	run.watch = nil
+	if run.dryRun {
+		return checkBrewLock(run, entries, true)
+	}
+	homebrewStatus = tracker.Status()
+	if err != nil {
+		if failed := homebrewStatus.Failed; len(failed) > 0 {
+			return fmt.Errorf("failed to install %s from Brewfile %s: %w", strings.Join(failed, ", "), brewfile.Path, err)
+		}
+		return fmt.Errorf("failed to install packages from Brewfile %s: %w", brewfile.Path, err)
+	}
+	return checkBrewLock(run, entries, true)
+}
+
+func (homebrewManager) Check(ctx context.Context, config *InstallConfig, packages []string) ([]string, error) {
+	brewfile, err := loadBrewfile(config)
+	if err != nil {
+		return nil, err
+	}
+	missing, err := bundleMissing(ctx, brewfile, brewEntries(brewfile, packages, nil))
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, entry := range missing {
+		keys = append(keys, entry.Key())
+	}
+	return keys, nil
+}
+
+// Installed lists installed formulae and casks as Brewfile entry keys
+func (homebrewManager) Installed(ctx context.Context) ([]string, error) {
+	var keys []string
+	for _, kind := range []string{BrewFormula, BrewCask} {
+		flag := "--formula"
+		if kind == BrewCask {
+			flag = "--cask"
+		}
+		output, err := brewOutput(ctx, "list", "-1", flag)
+		if err != nil {
+			return nil, fmt.Errorf("brew list failed: %w", err)
+		}
+		for _, name := range strings.Fields(output) {
+			keys = append(keys, kind+" "+name)
+		}
+	}
+	return keys, nil
+}
+
+// brewEntries returns the Brewfile entries whose keys are in packages and
+// not in skip, in Brewfile order
+func brewEntries(brewfile *Brewfile, packages []string, skip map[string]bool) []BrewEntry {
+	var entries []BrewEntry
+	for _, entry := range brewfile.Selected(skip) {
+		if containsString(packages, entry.Key()) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// systemManager installs packages with a Linux distribution's package
+// manager, through sudo unless already running as root
+type systemManager struct {
+	name      string
+	tool      string
+	env       []string                 // Environment for the package manager's commands
+	refresh   []string                 // Updates the package index before installing, if set
+	install   []string                 // Install command, followed by the package names
+	installed []string                 // Prints the installed packages, one per line
+	parse     func(line string) string // Picks the package name out of a line of installed, if set
+	groups    string                   // Marks package groups, such as dnf's @development-tools, if set
+	list      func(config *InstallConfig) []string
+}
+
+func (m systemManager) Name() string { return m.name }
+func (m systemManager) Tool() string { return m.tool }
+
+// Bootstrap only checks that the package manager is there; it comes with
+// the distribution
+func (m systemManager) Bootstrap(run *stepRun) error {
+	if err := run.requireTool(m.tool); err != nil {
+		return fmt.Errorf("%s not found: %w", m.tool, err)
+	}
+	return nil
+}
+
+func (m systemManager) Packages(config *InstallConfig) ([]string, error) {
+	return m.list(config), nil
+}
+
+func (m systemManager) Install(run *stepRun, packages []string) error {
+	if len(packages) == 0 {
+		run.output("# no " + m.name + " packages configured")
+		return nil
+	}
+	if m.refresh != nil {
+		if err := run.runCommand(Command{Args: m.privileged(m.refresh)}); err != nil {
+			return fmt.Errorf("failed to update the %s package index: %w", m.name, err)
+		}
+	}
+	args := append(append([]string{}, m.install...), packages...)
+	if err := run.runCommand(Command{Args: m.privileged(args)}); err != nil {
+		return fmt.Errorf("failed to install %s packages: %w", m.name, err)
+	}
+	return nil
+}
+
+// privileged runs args as root: through sudo -n, which fails rather than
+// prompting for a password the TUI cannot read
+func (m systemManager) privileged(args []string) []string {
+	if m.env != nil {
+		args = append(append([]string{"env"}, m.env...), args...)
+	}
+	if os.Geteuid() == 0 {
+		return args
+	}
+	return append([]string{"sudo", "-n"}, args...)
+}
+
+func (m systemManager) Check(ctx context.Context, config *InstallConfig, packages []string) ([]string, error) {
+	installed, err := m.Installed(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Groups are not packages of their own, so installed does not list
+	// them; their members are what gets installed
+	var names []string
+	for _, name := range packages {
+		if m.groups == "" || !strings.HasPrefix(name, m.groups) {
+			names = append(names, name)
+		}
+	}
+	return missingPackages(names, installed), nil
+}
+
+func (m systemManager) Installed(ctx context.Context) ([]string, error) {
+	output, err := exec.CommandContext(ctx, m.installed[0], m.installed[1:]...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %w", strings.Join(m.installed, " "), err)
+	}
+	var names []string
+	for _, line := range strings.Split(string(output), "\n") {
+		name := strings.TrimSpace(line)
+		if m.parse != nil {
+			name = m.parse(line)
+		}
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// nixFlags enables the nix profile commands on installs that have not
+// turned them on
+var nixFlags = []string{"--extra-experimental-features", "nix-command flakes"}
+
+// nixManager installs packages from nixpkgs into the user's Nix profile
+type nixManager struct{}
+
+func (nixManager) Name() string { return ManagerNix }
+func (nixManager) Tool() string { return "nix" }
+
+// Bootstrap runs the single-user Nix installer when nix is missing, with
+// the install script from packages.nix_installer, then puts the profile on
+// PATH for the rest of the run
+func (nixManager) Bootstrap(run *stepRun) error {
+	if _, err := exec.LookPath("nix"); err == nil {
+		return nil
+	}
+	installer := installScript{
+		name:       "Nix",
+		setting:    "packages.nix_installer.sha256",
+		defaultURL: defaultNixInstallerURL,
+		config:     run.config.Packages.NixInstaller,
+		shell:      []string{"/bin/sh"},
+		args:       []string{"--no-daemon"},
+	}
+	if err := installer.run(run); err != nil {
+		return err
+	}
+	if !run.dryRun {
+		os.Setenv("PATH", filepath.Join(homeDir, ".nix-profile", "bin")+string(os.PathListSeparator)+os.Getenv("PATH"))
+	}
+	return nil
+}
+
+func (nixManager) Packages(config *InstallConfig) ([]string, error) {
+	return config.Packages.Nix, nil
+}
+
+func (nixManager) Install(run *stepRun, packages []string) error {
+	if len(packages) == 0 {
+		run.output("# no nix packages configured")
+		return nil
+	}
+	args := append(append([]string{"nix"}, nixFlags...), "profile", "install")
+	for _, name := range packages {
+		args = append(args, "nixpkgs#"+name)
+	}
+	if err := run.runCommand(Command{Args: args}); err != nil {
+		return fmt.Errorf("failed to install nix packages: %w", err)
+	}
+	return nil
+}
+
+func (m nixManager) Check(ctx context.Context, config *InstallConfig, packages []string) ([]string, error) {
+	installed, err := m.Installed(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return missingPackages(packages, installed), nil
+}
+
+// Installed lists the packages in the user's profile. Newer Nix versions
+// key profile elements by name; older ones list them with an attribute
+// path such as legacyPackages.x86_64-linux.ripgrep.
+func (nixManager) Installed(ctx context.Context) ([]string, error) {
+	args := append(append([]string{}, nixFlags...), "profile", "list", "--json")
+	output, err := exec.CommandContext(ctx, "nix", args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("nix profile list failed: %w", err)
+	}
+	var profile struct {
+		Elements json.RawMessage `json:"elements"`
+	}
+	if err := json.Unmarshal(output, &profile); err != nil {
+		return nil, fmt.Errorf("failed to read nix profile: %w", err)
+	}
+
+	var names []string
+	var byName map[string]json.RawMessage
+	var list []struct {
+		AttrPath string `json:"attrPath"`
+	}
+	switch {
+	case json.Unmarshal(profile.Elements, &byName) == nil:
+		for name := range byName {
+			names = append(names, name)
+		}
+	case json.Unmarshal(profile.Elements, &list) == nil:
+		for _, element := range list {
+			names = append(names, element.AttrPath[strings.LastIndex(element.AttrPath, ".")+1:])
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
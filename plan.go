@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ActionKind identifies the kind of work a PlannedAction stands for
+type ActionKind int
+
+const (
+	ActionCommand ActionKind = iota
+	ActionCopy
+	ActionCheck
+	ActionNote
+	ActionLink
+	ActionBlock
+)
+
+// PlannedAction is one side effect a step would have, recorded in dry-run mode
+type PlannedAction struct {
+	Kind      ActionKind
+	Command   []string // ActionCommand
+	Source    string   // ActionCopy, ActionLink, ActionBlock
+	Dest      string   // ActionCopy, ActionCheck, ActionLink, ActionBlock
+	Overwrite bool     // ActionCopy, ActionLink, ActionBlock: Dest already exists
+	Unchanged bool     // ActionCopy, ActionBlock: Dest already matches and is left alone
+	Missing   bool     // ActionCheck: tool not currently in PATH
+	Note      string   // ActionNote, or extra context for other kinds
+}
+
+// String renders the action as a single plan line
+func (a PlannedAction) String() string {
+	var line string
+	switch a.Kind {
+	case ActionCommand:
+		line = "run " + strings.Join(a.Command, " ")
+	case ActionCopy:
+		marker := "[create]"
+		if a.Overwrite {
+			marker = "[overwrite]"
+		} else if a.Unchanged {
+			marker = "[unchanged]"
+		}
+		line = fmt.Sprintf("copy %s %s → %s", marker, a.Source, a.Dest)
+	case ActionLink:
+		marker := "[create]"
+		if a.Overwrite {
+			marker = "[replace]"
+		}
+		line = fmt.Sprintf("link %s %s → %s", marker, a.Dest, a.Source)
+	case ActionBlock:
+		marker := "[create]"
+		if a.Overwrite {
+			marker = "[update]"
+		} else if a.Unchanged {
+			marker = "[unchanged]"
+		}
+		line = fmt.Sprintf("block %s %s → %s", marker, a.Source, a.Dest)
+	case ActionCheck:
+		line = fmt.Sprintf("check %s in PATH", a.Dest)
+		if a.Missing {
+			line += " (currently missing)"
+		}
+	case ActionNote:
+		return "note: " + a.Note
+	}
+	if a.Note != "" {
+		line += " (" + a.Note + ")"
+	}
+	return line
+}
+
+// countsAsProgress reports whether the action is a unit of step progress
+func (a PlannedAction) countsAsProgress() bool {
+	return a.Kind == ActionCommand || a.Kind == ActionCopy || a.Kind == ActionLink || a.Kind == ActionBlock
+}
+
+// dryRunStep walks a step's Apply in dry-run mode and returns what it would do
+func dryRunStep(step Step, config *InstallConfig) []PlannedAction {
+	run := &stepRun{
+		inst:   &installation{ctx: context.Background(), config: config, send: discardMsg, total: 1},
+		ctx:    context.Background(),
+		step:   step,
+		config: config,
+		units:  1,
+		dryRun: true,
+	}
+	if err := step.Apply(run); err != nil {
+		run.record(PlannedAction{Kind: ActionNote, Note: "would fail: " + err.Error()})
+	}
+	return run.actions
+}
+
+// formatPlan renders the plan of every enabled step for printing
+func formatPlan(config *InstallConfig) string {
+	var lines []string
+	for _, step := range stepRegistry {
+		if !step.Enabled(config) {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("== %s (%s)", step.Title(), step.ID()))
+		actions := step.Plan(config)
+		if len(actions) == 0 {
+			lines = append(lines, "   nothing to do")
+		}
+		for _, action := range actions {
+			lines = append(lines, "   "+action.String())
+		}
+		lines = append(lines, "")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// printPlan writes the dry-run plan for the loaded configuration to stdout
+func printPlan() error {
+	config, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "MacDevTUI v%s - installation plan (dry run, nothing will be changed)\n\n", Version)
+	fmt.Fprint(os.Stdout, formatPlan(config))
+	return nil
+}
@@ -0,0 +1,1043 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// InstallEvent identifies what an InstallMsg reports
+type InstallEvent int
+
+const (
+	EventStepStarted InstallEvent = iota
+	EventCommandStarted
+	EventProgress
+	EventStepFinished
+	EventInstallFinished
+)
+
+// commandKillDelay is how long a cancelled command may take to exit after
+// SIGTERM before it is killed
+const commandKillDelay = 5 * time.Second
+
+// stderrTailLines is how many trailing stderr lines a CommandError keeps
+const stderrTailLines = 5
+
+// OutputMsg carries one line of a step's command output to the TUI
+type OutputMsg struct {
+	StepID string
+	Line   string
+}
+
+// FileMsg tells the TUI what a step did to one destination file
+type FileMsg struct {
+	StepID string
+	File   fileResult
+}
+
+// CommandError is returned when a spawned command fails, keeping the
+// last lines it wrote to stderr so they can be shown to the user
+type CommandError struct {
+	Command    []string
+	Err        error
+	StderrTail []string
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("%s: %v", strings.Join(e.Command, " "), e.Err)
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// TimeoutError is returned when a step or command runs past its timeout
+type TimeoutError struct {
+	Limit   time.Duration
+	Elapsed time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("timed out after %s (timeout %s)", e.Elapsed.Round(100*time.Millisecond), e.Limit)
+}
+
+// Is lets errors.Is match a TimeoutError against context.DeadlineExceeded
+func (e *TimeoutError) Is(target error) bool {
+	return target == context.DeadlineExceeded
+}
+
+// lineWriter is an io.Writer that calls onLine for every complete line
+type lineWriter struct {
+	onLine  func(string)
+	pending []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.pending = append(w.pending, p...)
+	for {
+		i := bytes.IndexByte(w.pending, '\n')
+		if i < 0 {
+			break
+		}
+		w.onLine(strings.TrimRight(string(w.pending[:i]), "\r"))
+		w.pending = w.pending[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush emits any trailing output that did not end in a newline
+func (w *lineWriter) Flush() {
+	if len(w.pending) > 0 {
+		w.onLine(strings.TrimRight(string(w.pending), "\r"))
+		w.pending = nil
+	}
+}
+
+// program is the running TUI that installer events are pushed to
+var program *tea.Program
+
+// sendToProgram delivers a message to the running TUI, if there is one
+func sendToProgram(msg tea.Msg) {
+	if program != nil {
+		program.Send(msg)
+	}
+}
+
+// discardMsg drops messages when no TUI is listening
+func discardMsg(tea.Msg) {}
+
+// copyWarning records something a step could not copy faithfully
+type copyWarning struct {
+	StepID  string
+	Message string
+}
+
+// What a step did to a destination file
+const (
+	FileCreated   = "created"
+	FileUpdated   = "updated"
+	FileUnchanged = "unchanged"
+)
+
+// fileResult records whether a step created, updated or left alone a file
+type fileResult struct {
+	Path   string
+	Change string // FileCreated, FileUpdated or FileUnchanged
+}
+
+// String renders the result with the path relative to the home directory
+func (f fileResult) String() string {
+	path := f.Path
+	if rel, err := filepath.Rel(homeDir, path); err == nil && !strings.HasPrefix(rel, "..") {
+		path = "~/" + rel
+	}
+	return f.Change + " " + path
+}
+
+// summarizeFiles counts file results by change, e.g. "2 created, 5 unchanged"
+func summarizeFiles(files []fileResult) string {
+	var parts []string
+	for _, change := range []string{FileCreated, FileUpdated, FileUnchanged} {
+		count := 0
+		for _, file := range files {
+			if file.Change == change {
+				count++
+			}
+		}
+		if count > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", count, change))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// stepOutcome records how an executed step ended
+type stepOutcome struct {
+	StepID string
+	Status InstallStatus
+	Err    error
+}
+
+// installation tracks overall progress across the enabled steps of a run.
+// Steps may run concurrently, so progress and outcomes are guarded by mu.
+type installation struct {
+	ctx    context.Context
+	config *InstallConfig
+	send   func(tea.Msg)
+	total  int // Number of enabled steps
+
+	mu          sync.Mutex
+	stepPercent map[string]int // Progress of each started step, 0-100
+	outcomes    []stepOutcome
+	warnings    []copyWarning
+	files       map[string][]fileResult // Written files by step ID
+
+	checkpoint   *Checkpoint     // Persisted progress; nil in dry-run mode
+	backup       *BackupSet      // Files replaced by this run; nil in dry-run mode
+	keepFiles    map[string]bool // Destinations the user chose to keep when reviewing diffs
+	skipPackages map[string]bool // Brewfile entries deselected in the TUI, by BrewEntry.Key
+}
+
+// newInstallation creates a run over total enabled steps that stops when
+// ctx is cancelled
+func newInstallation(ctx context.Context, config *InstallConfig, total int) *installation {
+	if total < 1 {
+		total = 1
+	}
+	return &installation{
+		ctx:        ctx,
+		config:     config,
+		send:       sendToProgram,
+		total:      total,
+		checkpoint: newCheckpoint(config),
+		backup:     newBackupSet(),
+	}
+}
+
+// isCancellation reports whether err is the result of the run being cancelled
+func isCancellation(err error) bool {
+	return errors.Is(err, context.Canceled)
+}
+
+// continuedError holds the failures of commands whose policy is to
+// continue. It fails their step, but unlike other step errors does not
+// stop the steps after it, whatever the step's own policy.
+type continuedError struct {
+	failures []error
+}
+
+func (e *continuedError) Error() string   { return errors.Join(e.failures...).Error() }
+func (e *continuedError) Unwrap() []error { return e.failures }
+
+// isContinued reports whether err only holds failures of commands whose
+// policy is to continue
+func isContinued(err error) bool {
+	var continued *continuedError
+	return errors.As(err, &continued)
+}
+
+// stepRun carries the state of a single step while it executes
+type stepRun struct {
+	inst   *installation
+	ctx    context.Context // The run's context, limited by the step timeout
+	step   Step
+	config *InstallConfig
+	units  int // Planned actions, used to compute step progress
+	done   int
+
+	partial float64           // How far the current action has got, 0-1, from its output
+	watch   func(line string) // Called with each output line of the running command
+
+	dryRun  bool            // Record actions instead of performing them
+	actions []PlannedAction // Actions recorded in dry-run mode
+
+	failures []error // Failures of commands whose policy is to continue
+
+	newLinks []string // Symlinks copied since the last check that they resolve
+}
+
+// runStep runs a step under its failure policy, retrying the whole step
+// when asked to. It returns the error of the final attempt.
+func (inst *installation) runStep(step Step) error {
+	if inst.checkpoint != nil && inst.checkpoint.StepDone(step.ID()) {
+		logger.Printf("Skipping step %s, completed in a previous run", step.ID())
+		inst.skipStep(step)
+		return nil
+	}
+
+	policy := step.Options(inst.config).FailurePolicy
+	for attempt := 1; ; attempt++ {
+		run := inst.startStep(step, attempt)
+		err := run.apply()
+		if err == nil && len(run.failures) > 0 {
+			err = &continuedError{failures: run.failures}
+		}
+		if err == nil || isCancellation(err) || attempt >= policy.MaxAttempts() {
+			inst.finishStep(run, err)
+			return err
+		}
+
+		delay := policy.RetryDelay(attempt)
+		logger.Printf("Step %s failed (attempt %d/%d), retrying in %s: %v", step.ID(), attempt, policy.MaxAttempts(), delay, err)
+		if waitErr := inst.wait(delay); waitErr != nil {
+			inst.finishStep(run, waitErr)
+			return waitErr
+		}
+	}
+}
+
+// skipStep reports a step completed by a resumed checkpoint as done
+func (inst *installation) skipStep(step Step) {
+	progress := inst.recordOutcome(stepOutcome{StepID: step.ID(), Status: StatusComplete})
+	inst.send(InstallMsg{
+		Event:    EventStepFinished,
+		StepID:   step.ID(),
+		Status:   StatusComplete,
+		Progress: progress,
+		Message:  fmt.Sprintf("%s already done, skipped", step.Title()),
+	})
+}
+
+// recordProgress stores a step's progress and returns the overall progress
+func (inst *installation) recordProgress(stepID string, percent int) int {
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+	if inst.stepPercent == nil {
+		inst.stepPercent = make(map[string]int)
+	}
+	inst.stepPercent[stepID] = percent
+
+	sum := 0
+	for _, p := range inst.stepPercent {
+		sum += p
+	}
+	return sum / inst.total
+}
+
+// recordOutcome stores how a step ended and returns the overall progress
+func (inst *installation) recordOutcome(outcome stepOutcome) int {
+	inst.mu.Lock()
+	inst.outcomes = append(inst.outcomes, outcome)
+	inst.mu.Unlock()
+	return inst.recordProgress(outcome.StepID, 100)
+}
+
+// Outcomes returns a copy of the outcomes recorded so far
+func (inst *installation) Outcomes() []stepOutcome {
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+	return append([]stepOutcome{}, inst.outcomes...)
+}
+
+// Warnings returns a copy of the copy warnings recorded so far
+func (inst *installation) Warnings() []copyWarning {
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+	return append([]copyWarning{}, inst.warnings...)
+}
+
+// Files returns a copy of the file results recorded for a step
+func (inst *installation) Files(stepID string) []fileResult {
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+	return append([]fileResult{}, inst.files[stepID]...)
+}
+
+// wait sleeps for d unless the run is cancelled first
+func (inst *installation) wait(d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-inst.ctx.Done():
+		return inst.ctx.Err()
+	}
+}
+
+// finishedMsg builds the final message of a run from its step outcomes
+func (inst *installation) finishedMsg() InstallMsg {
+	var failed []error
+	firstFailed := ""
+	for _, outcome := range inst.Outcomes() {
+		if outcome.Status != StatusError {
+			continue
+		}
+		if firstFailed == "" {
+			firstFailed = outcome.StepID
+		}
+		failed = append(failed, fmt.Errorf("%s: %w", outcome.StepID, outcome.Err))
+	}
+	if len(failed) == 0 {
+		return InstallMsg{Event: EventInstallFinished, Status: StatusComplete, Progress: 100}
+	}
+	return InstallMsg{
+		Event:   EventInstallFinished,
+		StepID:  firstFailed,
+		Status:  StatusError,
+		Error:   errors.Join(failed...),
+		Message: fmt.Sprintf("Finished with %d failed step(s)", len(failed)),
+	}
+}
+
+// startStep announces a step and returns the run it should report through
+func (inst *installation) startStep(step Step, attempt int) *stepRun {
+	units := 0
+	for _, action := range step.Plan(inst.config) {
+		if action.countsAsProgress() {
+			units++
+		}
+	}
+	if units < 1 {
+		units = 1
+	}
+
+	run := &stepRun{inst: inst, ctx: inst.ctx, step: step, config: inst.config, units: units}
+	message := fmt.Sprintf("Running %s...", step.Title())
+	if attempt > 1 {
+		message = fmt.Sprintf("Retrying %s (attempt %d)...", step.Title(), attempt)
+	}
+	inst.send(InstallMsg{
+		Event:    EventStepStarted,
+		StepID:   step.ID(),
+		Status:   StatusInProgress,
+		Progress: run.overallProgress(),
+		Attempt:  attempt,
+		Message:  message,
+	})
+	return run
+}
+
+// finishStep announces the outcome of a step and advances overall progress
+func (inst *installation) finishStep(run *stepRun, err error) {
+	msg := InstallMsg{
+		Event:   EventStepFinished,
+		StepID:  run.step.ID(),
+		Status:  StatusComplete,
+		Message: fmt.Sprintf("%s complete", run.step.Title()),
+	}
+	if isCancellation(err) {
+		msg.Status = StatusCancelled
+		msg.Error = err
+		msg.Message = fmt.Sprintf("%s interrupted", run.step.Title())
+	} else if err != nil {
+		msg.Status = StatusError
+		msg.Error = err
+		msg.Message = fmt.Sprintf("%s failed", run.step.Title())
+	}
+	msg.Progress = inst.recordOutcome(stepOutcome{StepID: run.step.ID(), Status: msg.Status, Err: err})
+	if msg.Status == StatusComplete && inst.checkpoint != nil {
+		if cpErr := inst.checkpoint.MarkStep(run.step.ID()); cpErr != nil {
+			logger.Printf("Failed to save checkpoint: %v", cpErr)
+		}
+	}
+	inst.send(msg)
+}
+
+// apply runs one attempt of the step's work, stopping it once the step's
+// timeout expires
+func (r *stepRun) apply() error {
+	limit := r.step.Options(r.config).Timeout.Duration()
+	if limit == 0 {
+		return r.step.Apply(r)
+	}
+
+	ctx, cancel := context.WithTimeout(r.inst.ctx, limit)
+	defer cancel()
+	r.ctx = ctx
+	defer func() { r.ctx = r.inst.ctx }()
+
+	started := time.Now()
+	err := r.step.Apply(r)
+	if err != nil && ctx.Err() == context.DeadlineExceeded && r.inst.ctx.Err() == nil {
+		timeoutErr := &TimeoutError{Limit: limit, Elapsed: time.Since(started)}
+		logger.Printf("[%s] Step %v: %v", r.step.ID(), timeoutErr, err)
+		return fmt.Errorf("step %w", timeoutErr)
+	}
+	return err
+}
+
+// stepProgress returns how far the step is through its planned actions
+func (r *stepRun) stepProgress() int {
+	percent := int((float64(r.done) + r.partial) * 100 / float64(r.units))
+	if percent > 100 {
+		percent = 100
+	}
+	return percent
+}
+
+// overallProgress records this step's progress and returns the progress
+// across all enabled steps
+func (r *stepRun) overallProgress() int {
+	return r.inst.recordProgress(r.step.ID(), r.stepProgress())
+}
+
+// advance marks one planned action as done and reports progress
+func (r *stepRun) advance(message string) {
+	r.done++
+	r.partial = 0
+	r.inst.send(InstallMsg{
+		Event:        EventProgress,
+		StepID:       r.step.ID(),
+		Status:       StatusInProgress,
+		Progress:     r.overallProgress(),
+		StepProgress: r.stepProgress(),
+		Message:      message,
+	})
+}
+
+// progress reports how far the current action has got, as a fraction
+func (r *stepRun) progress(fraction float64, message string) {
+	r.partial = fraction
+	r.inst.send(InstallMsg{
+		Event:        EventProgress,
+		StepID:       r.step.ID(),
+		Status:       StatusInProgress,
+		Progress:     r.overallProgress(),
+		StepProgress: r.stepProgress(),
+		Message:      message,
+	})
+}
+
+// output forwards a line of command output to the TUI
+func (r *stepRun) output(line string) {
+	r.inst.send(OutputMsg{StepID: r.step.ID(), Line: line})
+}
+
+// warn records something the step could not reproduce faithfully
+func (r *stepRun) warn(message string) {
+	logger.Printf("[%s] Warning: %s", r.step.ID(), message)
+	r.output("# warning: " + message)
+	r.inst.mu.Lock()
+	r.inst.warnings = append(r.inst.warnings, copyWarning{StepID: r.step.ID(), Message: message})
+	r.inst.mu.Unlock()
+}
+
+// wrote records what the step did to dest and advances step progress
+func (r *stepRun) wrote(dest, change, message string) {
+	result := fileResult{Path: dest, Change: change}
+	logger.Printf("[%s] %s", r.step.ID(), result)
+	r.output("# " + result.String())
+	r.inst.mu.Lock()
+	if r.inst.files == nil {
+		r.inst.files = make(map[string][]fileResult)
+	}
+	r.inst.files[r.step.ID()] = append(r.inst.files[r.step.ID()], result)
+	r.inst.mu.Unlock()
+	r.inst.send(FileMsg{StepID: r.step.ID(), File: result})
+	r.advance(message)
+}
+
+// record adds an action to the dry-run plan
+func (r *stepRun) record(action PlannedAction) {
+	r.actions = append(r.actions, action)
+}
+
+// runCommand runs a command under its failure policy and advances step
+// progress. A failure the policy continues past is kept for the step result.
+func (r *stepRun) runCommand(cmd Command) error {
+	if r.dryRun {
+		r.record(PlannedAction{Kind: ActionCommand, Command: cmd.Args, Note: cmd.Settings()})
+		return nil
+	}
+
+	checkpoint := r.inst.checkpoint
+	if checkpoint != nil && checkpoint.CommandDone(r.step.ID(), cmd) {
+		r.output("# skipped, completed in a previous run: " + cmd.String())
+		r.advance(fmt.Sprintf("%s: %s already done", r.step.Title(), cmd.Args[0]))
+		return nil
+	}
+
+	policy := cmd.FailurePolicy
+	var err error
+	for attempt := 1; attempt <= policy.MaxAttempts(); attempt++ {
+		if attempt > 1 {
+			delay := policy.RetryDelay(attempt - 1)
+			r.output(fmt.Sprintf("# retrying in %s (attempt %d/%d)", delay, attempt, policy.MaxAttempts()))
+			if waitErr := r.inst.wait(delay); waitErr != nil {
+				return waitErr
+			}
+		}
+		err = r.execCommand(cmd)
+		// Once the run is cancelled or the step timed out, retrying is futile
+		if err == nil || r.ctx.Err() != nil {
+			break
+		}
+	}
+
+	if err == nil {
+		if checkpoint != nil {
+			if cpErr := checkpoint.MarkCommand(r.step.ID(), cmd); cpErr != nil {
+				logger.Printf("Failed to save checkpoint: %v", cpErr)
+			}
+		}
+		r.advance(fmt.Sprintf("%s: finished %s", r.step.Title(), cmd.Args[0]))
+		return nil
+	}
+	if policy.Continues() && r.ctx.Err() == nil {
+		logger.Printf("[%s] Continuing past failed command: %v", r.step.ID(), err)
+		r.failures = append(r.failures, err)
+		r.advance(fmt.Sprintf("%s: %s failed, continuing", r.step.Title(), cmd.Args[0]))
+		return nil
+	}
+	return err
+}
+
+// execCommand announces and runs a single attempt of a command, streaming
+// its output to the log and the TUI. A command that outlives its timeout is
+// stopped like a cancelled one and fails with a TimeoutError.
+func (r *stepRun) execCommand(command Command) error {
+	if err := r.ctx.Err(); err != nil {
+		return err
+	}
+	cmd := command.Args
+
+	ctx := r.ctx
+	if limit := command.Timeout.Duration(); limit > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(r.ctx, limit)
+		defer cancel()
+	}
+
+	r.inst.send(InstallMsg{
+		Event:        EventCommandStarted,
+		StepID:       r.step.ID(),
+		Status:       StatusInProgress,
+		Progress:     r.overallProgress(),
+		StepProgress: r.stepProgress(),
+		Message:      fmt.Sprintf("%s: %s", r.step.Title(), strings.Join(cmd, " ")),
+	})
+	logger.Printf("[%s] Running: %s", r.step.ID(), strings.Join(cmd, " "))
+	r.output("$ " + strings.Join(cmd, " "))
+
+	// stdout and stderr are copied concurrently; the watcher sees one line at a time
+	var watchMu sync.Mutex
+	watch := func(line string) {
+		if r.watch != nil {
+			watchMu.Lock()
+			r.watch(line)
+			watchMu.Unlock()
+		}
+	}
+
+	var stderrTail []string
+	stdout := &lineWriter{onLine: func(line string) {
+		logger.Printf("[%s] %s", r.step.ID(), line)
+		r.output(line)
+		watch(line)
+	}}
+	stderr := &lineWriter{onLine: func(line string) {
+		logger.Printf("[%s] stderr: %s", r.step.ID(), line)
+		r.output(line)
+		watch(line)
+		stderrTail = append(stderrTail, line)
+		if len(stderrTail) > stderrTailLines {
+			stderrTail = stderrTail[1:]
+		}
+	}}
+
+	started := time.Now()
+	c := exec.CommandContext(ctx, cmd[0], cmd[1:]...)
+	c.Stdout = stdout
+	c.Stderr = stderr
+	// Run in its own process group so cancelling also stops the children
+	// it spawns, such as the installers started by brew bundle
+	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	c.Cancel = func() error {
+		return syscall.Kill(-c.Process.Pid, syscall.SIGTERM)
+	}
+	c.WaitDelay = commandKillDelay
+	err := c.Run()
+	stdout.Flush()
+	stderr.Flush()
+	if ctxErr := r.ctx.Err(); ctxErr != nil {
+		logger.Printf("[%s] Command interrupted: %s", r.step.ID(), strings.Join(cmd, " "))
+		return &CommandError{Command: cmd, Err: ctxErr, StderrTail: stderrTail}
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		timeoutErr := &TimeoutError{Limit: command.Timeout.Duration(), Elapsed: time.Since(started)}
+		logger.Printf("[%s] Command %v: %s", r.step.ID(), timeoutErr, strings.Join(cmd, " "))
+		r.output("# " + timeoutErr.Error())
+		return &CommandError{Command: cmd, Err: timeoutErr, StderrTail: stderrTail}
+	}
+	if err != nil {
+		logger.Printf("[%s] Command failed: %s: %v", r.step.ID(), strings.Join(cmd, " "), err)
+		return &CommandError{Command: cmd, Err: err, StderrTail: stderrTail}
+	}
+	return nil
+}
+
+// requireTool checks that a tool is in PATH. In dry-run mode a missing
+// tool is recorded instead of failing, since earlier steps may install it.
+func (r *stepRun) requireTool(tool string) error {
+	_, err := exec.LookPath(tool)
+	if r.dryRun {
+		r.record(PlannedAction{Kind: ActionCheck, Dest: tool, Missing: err != nil})
+		return nil
+	}
+	return err
+}
+
+// ensureDir creates a directory unless running in dry-run mode
+func (r *stepRun) ensureDir(path string) error {
+	if r.dryRun {
+		return nil
+	}
+	return os.MkdirAll(path, 0755)
+}
+
+// copyFile copies a single file and advances step progress. Permission
+// bits are kept and symlinks are recreated as symlinks; special files such
+// as sockets and devices are skipped with a warning. A destination that
+// already matches is left alone; any other is moved into the run's backup
+// set first.
+func (r *stepRun) copyFile(src, dest string) error {
+	if err := r.copyEntry(src, dest); err != nil {
+		return err
+	}
+	r.checkLinks()
+	return nil
+}
+
+// copyEntry does the work of copyFile, leaving copied symlinks to be
+// checked once their targets may have been copied too
+func (r *stepRun) copyEntry(src, dest string) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	special := !info.Mode().IsRegular() && info.Mode()&os.ModeSymlink == 0
+	templated := info.Mode().IsRegular() && isTemplate(src, r.config)
+	if templated {
+		dest = templateDest(src, dest)
+	}
+
+	var data []byte // Rendered template
+	if templated {
+		if data, err = renderTemplate(src, r.config); err != nil {
+			return err
+		}
+	}
+
+	if r.dryRun {
+		action := PlannedAction{Kind: ActionCopy, Source: src, Dest: dest}
+		var notes []string
+		switch {
+		case templated:
+			notes = append(notes, "rendered from template")
+		case special:
+			action.Kind = ActionNote
+			action.Note = fmt.Sprintf("skip %s (%s cannot be copied)", src, describeFileType(info.Mode()))
+			r.record(action)
+			return nil
+		case info.Mode()&os.ModeSymlink != 0:
+			target, _ := os.Readlink(src)
+			notes = append(notes, "symlink to "+target)
+		case info.Mode()&0111 != 0:
+			notes = append(notes, fmt.Sprintf("mode %04o", info.Mode().Perm()))
+		}
+		if change, err := destChange(src, dest, info, data, templated); err == nil && change == FileUnchanged {
+			action.Unchanged = true
+		} else if _, err := os.Lstat(dest); err == nil {
+			action.Overwrite = true
+			notes = append(notes, "existing file backed up first")
+		}
+		action.Note = strings.Join(notes, ", ")
+		r.record(action)
+		return nil
+	}
+	if err := r.ctx.Err(); err != nil {
+		return err
+	}
+	if r.inst.keepFiles[dest] {
+		r.output("# kept current file, skipped in review: " + dest)
+		r.advance(fmt.Sprintf("Kept %s", filepath.Base(dest)))
+		return nil
+	}
+
+	if special {
+		r.warn(fmt.Sprintf("skipped %s: %s cannot be copied", src, describeFileType(info.Mode())))
+		r.advance(fmt.Sprintf("Skipped %s", filepath.Base(src)))
+		return nil
+	}
+
+	// Identical files are left alone, so re-runs touch nothing
+	change, err := destChange(src, dest, info, data, templated)
+	if err != nil {
+		return err
+	}
+	if change == FileUnchanged {
+		r.wrote(dest, change, fmt.Sprintf("%s unchanged", filepath.Base(dest)))
+		return nil
+	}
+
+	if destInfo, err := os.Lstat(dest); err == nil && !destInfo.IsDir() && r.inst.backup != nil {
+		if err := r.inst.backup.Add(r.step.ID(), dest); err != nil {
+			return err
+		}
+		r.output("# backed up " + dest)
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		if err := r.copySymlink(src, dest); err != nil {
+			return err
+		}
+		r.wrote(dest, change, fmt.Sprintf("Linked %s", filepath.Base(src)))
+		return nil
+	}
+
+	if templated {
+		if err := writeFile(dest, data, info.Mode()&preservedModeBits); err != nil {
+			return err
+		}
+		r.wrote(dest, change, fmt.Sprintf("Rendered %s", filepath.Base(dest)))
+		return nil
+	}
+
+	if err := copyFile(src, dest); err != nil {
+		return err
+	}
+	if extra := info.Mode() & (os.ModeSetuid | os.ModeSetgid | os.ModeSticky); extra != 0 {
+		if destInfo, err := os.Stat(dest); err != nil || destInfo.Mode()&extra != extra {
+			r.warn(fmt.Sprintf("%s: could not keep setuid, setgid or sticky bits", dest))
+		}
+	}
+	r.wrote(dest, change, fmt.Sprintf("Copied %s", filepath.Base(src)))
+	return nil
+}
+
+// destChange compares dest with what copying src would write: the same
+// symlink target, or the same content and mode, leaves it unchanged.
+// Templates are compared by their rendered data.
+func destChange(src, dest string, info os.FileInfo, data []byte, templated bool) (string, error) {
+	destInfo, err := os.Lstat(dest)
+	if errors.Is(err, os.ErrNotExist) {
+		return FileCreated, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		if destInfo.Mode()&os.ModeSymlink == 0 {
+			return FileUpdated, nil
+		}
+		srcTarget, err := os.Readlink(src)
+		if err != nil {
+			return "", err
+		}
+		if destTarget, err := os.Readlink(dest); err != nil || destTarget != srcTarget {
+			return FileUpdated, nil
+		}
+		return FileUnchanged, nil
+	}
+	if !destInfo.Mode().IsRegular() || destInfo.Mode()&preservedModeBits != info.Mode()&preservedModeBits {
+		return FileUpdated, nil
+	}
+
+	want := sha256.Sum256(data)
+	if !templated {
+		if want, err = fileDigest(src); err != nil {
+			return "", err
+		}
+	}
+	have, err := fileDigest(dest)
+	if err != nil {
+		return "", err
+	}
+	if have != want {
+		return FileUpdated, nil
+	}
+	return FileUnchanged, nil
+}
+
+// copySymlink recreates the symlink at src as dest, with the same target
+func (r *stepRun) copySymlink(src, dest string) error {
+	target, err := os.Readlink(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	if err := os.Symlink(target, dest); err != nil {
+		return err
+	}
+	r.newLinks = append(r.newLinks, dest)
+	return nil
+}
+
+// checkLinks warns about copied symlinks whose targets do not exist at
+// their destination
+func (r *stepRun) checkLinks() {
+	for _, link := range r.newLinks {
+		if _, err := os.Stat(link); err != nil {
+			target, _ := os.Readlink(link)
+			r.warn(fmt.Sprintf("symlink %s -> %s does not resolve at its destination", link, target))
+		}
+	}
+	r.newLinks = nil
+}
+
+// describeFileType names the kind of a file that is not a regular file,
+// directory or symlink
+func describeFileType(mode os.FileMode) string {
+	switch {
+	case mode&os.ModeNamedPipe != 0:
+		return "named pipe"
+	case mode&os.ModeSocket != 0:
+		return "socket"
+	case mode&os.ModeCharDevice != 0:
+		return "character device"
+	case mode&os.ModeDevice != 0:
+		return "device"
+	}
+	return "irregular file"
+}
+
+// linkFile points dest at src with a symlink and advances step progress.
+// A link that already points at src is left alone. Anything else at dest
+// is moved into the run's backup set, or reported as an error when the
+// options refuse conflicts.
+func (r *stepRun) linkFile(src, dest string, options LinkOptions) error {
+	// A link would expose the raw template, so templates are always rendered
+	if info, err := os.Stat(src); err == nil && info.Mode().IsRegular() && isTemplate(src, r.config) {
+		if !r.dryRun {
+			r.output("# " + src + " is a template, rendering it instead of linking")
+		}
+		return r.copyFile(src, dest)
+	}
+
+	linked, conflict := linkState(src, dest)
+	if r.dryRun {
+		action := PlannedAction{Kind: ActionLink, Source: src, Dest: dest, Overwrite: conflict != ""}
+		switch {
+		case linked:
+			action.Note = "already linked"
+		case conflict != "" && options.OnConflict == ConflictRefuse:
+			action.Note = "would refuse: " + conflict + " is in the way"
+		case conflict != "":
+			action.Note = conflict + " backed up first"
+		}
+		r.record(action)
+		return nil
+	}
+	if err := r.ctx.Err(); err != nil {
+		return err
+	}
+
+	if linked {
+		r.wrote(dest, FileUnchanged, fmt.Sprintf("%s already linked", filepath.Base(dest)))
+		return nil
+	}
+	change := FileCreated
+	if conflict != "" {
+		change = FileUpdated
+	}
+	if conflict != "" {
+		if options.OnConflict == ConflictRefuse {
+			return fmt.Errorf("refusing to replace %s with a link: %s is in the way (set on_conflict to backup to move it aside)", dest, conflict)
+		}
+		if r.inst.backup != nil {
+			if err := r.inst.backup.Add(r.step.ID(), dest); err != nil {
+				return err
+			}
+			r.output("# backed up " + dest)
+		}
+	}
+
+	target := src
+	if options.Link != LinkAbsolute {
+		rel, err := filepath.Rel(filepath.Dir(dest), src)
+		if err != nil {
+			return err
+		}
+		target = rel
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	if err := os.Symlink(target, dest); err != nil {
+		return err
+	}
+	logger.Printf("[%s] Linked %s -> %s", r.step.ID(), dest, target)
+	r.output(fmt.Sprintf("# linked %s -> %s", dest, target))
+	r.wrote(dest, change, fmt.Sprintf("Linked %s", filepath.Base(dest)))
+	return nil
+}
+
+// linkState reports whether dest is already a symlink resolving to src,
+// and otherwise describes what is in its way, if anything
+func linkState(src, dest string) (linked bool, conflict string) {
+	info, err := os.Lstat(dest)
+	if err != nil {
+		return false, ""
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		if info.IsDir() {
+			return false, "a directory"
+		}
+		return false, "a file"
+	}
+
+	target, err := os.Readlink(dest)
+	if err != nil {
+		return false, "an unreadable link"
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(dest), target)
+	}
+	if filepath.Clean(target) == filepath.Clean(src) {
+		return true, ""
+	}
+	return false, "a link to " + target
+}
+
+// copyDir copies a directory tree file by file like copyFile. Symlinks
+// inside the tree are recreated rather than followed, and directory modes
+// are applied once the tree is copied so read-only directories can be filled.
+// Paths the ignore matcher rejects are left out, ignored directories whole.
+func (r *stepRun) copyDir(src, dest string, ignore *ignoreMatcher) error {
+	type dirMode struct {
+		path string
+		mode os.FileMode
+	}
+	var dirs []dirMode
+
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+
+		destPath := filepath.Join(dest, relPath)
+
+		if relPath != "." && ignore.Ignored(relPath, info.IsDir()) {
+			if r.dryRun && relPath != ignoreFileName {
+				r.record(PlannedAction{Kind: ActionNote, Note: fmt.Sprintf("skip %s (ignored)", path)})
+			}
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.IsDir() {
+			if r.dryRun {
+				return nil
+			}
+			dirs = append(dirs, dirMode{destPath, info.Mode() & preservedModeBits})
+			return os.MkdirAll(destPath, 0755)
+		}
+
+		return r.copyEntry(path, destPath)
+	})
+	r.checkLinks()
+	if err != nil {
+		return err
+	}
+
+	// Innermost directories first, so parents stay writable until the end
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := os.Chmod(dirs[i].path, dirs[i].mode); err != nil {
+			r.warn(fmt.Sprintf("%s: could not set mode %04o: %v", dirs[i].path, dirs[i].mode.Perm(), err))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Step is one kind of installation work. Each implementation is registered
+// once and the TUI, the installer and the report all read from the registry.
+type Step interface {
+	// ID returns the stable identifier used in logs and reports
+	ID() string
+	// Title returns the display name of the step
+	Title() string
+	// Enabled reports whether the configuration turns the step on
+	Enabled(config *InstallConfig) bool
+	// Describe builds the SetupStep shown in the navigation and detail panes
+	Describe(config *InstallConfig) SetupStep
+	// Plan lists the actions Apply would take without performing them
+	Plan(config *InstallConfig) []PlannedAction
+	// Apply performs the step, reporting progress through run
+	Apply(run *stepRun) error
+	// Verify returns the tools the step expects to find in PATH afterwards
+	Verify(config *InstallConfig) []string
+	// Options returns the step's settings from its config section
+	Options(config *InstallConfig) StepOptions
+	// Fingerprint hashes everything the step's work depends on, so a
+	// checkpoint can tell when completed work is out of date
+	Fingerprint(config *InstallConfig) string
+}
+
+// stepReporter is implemented by steps that add their own report section
+type stepReporter interface {
+	// ReportHeading returns the section's Markdown heading
+	ReportHeading() string
+	// Report returns the section's lines
+	Report(config *InstallConfig) []string
+}
+
+// stepRegistry holds every known step in execution order
+var stepRegistry []Step
+
+func init() {
+	registerStep(homebrewStep{})
+	registerStep(terminalStep{})
+	registerStep(shellStep{})
+	registerStep(devToolsStep{})
+	registerStep(dotfilesStep{})
+	registerStep(verifyStep{})
+}
+
+// registerStep adds a step to the registry, panicking on duplicate IDs
+func registerStep(step Step) {
+	if _, exists := lookupStep(step.ID()); exists {
+		panic(fmt.Sprintf("step %q registered twice", step.ID()))
+	}
+	stepRegistry = append(stepRegistry, step)
+}
+
+// lookupStep finds a registered step by ID
+func lookupStep(id string) (Step, bool) {
+	for _, step := range stepRegistry {
+		if step.ID() == id {
+			return step, true
+		}
+	}
+	return nil, false
+}
+
+// newSetupStep fills in the fields shared by every step's description
+func newSetupStep(step Step, description string, items []string, estTime time.Duration) SetupStep {
+	return SetupStep{
+		ID:          step.ID(),
+		Title:       step.Title(),
+		Icon:        "▶",
+		Description: description,
+		Items:       items,
+		EstTime:     estTime,
+		Status:      StatusReady,
+		Enabled:     true,
+	}
+}
+
+// homebrewStep installs Homebrew and the packages from the Brewfile, or on
+// Linux the packages configured for the distribution's package manager
+type homebrewStep struct{}
+
+func (homebrewStep) ID() string    { return "homebrew" }
+func (homebrewStep) Title() string { return "Homebrew & Packages" }
+
+func (homebrewStep) Enabled(config *InstallConfig) bool { return config.Homebrew.Install }
+
+func (homebrewStep) Options(config *InstallConfig) StepOptions { return config.Homebrew.StepOptions }
+
+func (homebrewStep) Fingerprint(config *InstallConfig) string {
+	// Brewfile contents matter as much as the paths pointing at them
+	var brewfiles []string
+	for _, brewPath := range config.Homebrew.BrewfilePaths {
+		if data, err := os.ReadFile(expandPath(brewPath)); err == nil {
+			brewfiles = append(brewfiles, string(data))
+		}
+	}
+	return fingerprint(config.Homebrew, config.Packages, brewfiles)
+}
+
+// Describe lists the Brewfile's packages by kind, falling back to the
+// configured paths when no Brewfile can be read. Other package managers
+// list their configured packages.
+func (s homebrewStep) Describe(config *InstallConfig) SetupStep {
+	if name := packageManagerName(config); name != ManagerHomebrew {
+		var items []string
+		manager, err := selectPackageManager(config)
+		if err != nil {
+			items = append(items, err.Error())
+		} else if packages, _ := manager.Packages(config); len(packages) > 0 {
+			items = append(items, fmt.Sprintf("Packages (%d): %s", len(packages), strings.Join(packages, ", ")))
+		} else {
+			items = append(items, fmt.Sprintf("No packages configured under packages.%s", name))
+		}
+		setup := newSetupStep(s, fmt.Sprintf("Install packages with %s", name), items, 10*time.Minute)
+		setup.Title = fmt.Sprintf("Packages (%s)", name)
+		return setup
+	}
+
+	items := []string{"Homebrew package manager"}
+	brewfile, err := loadBrewfile(config)
+	if err != nil {
+		items = append(items,
+			fmt.Sprintf("Brewfile locations: %d paths configured", len(config.Homebrew.BrewfilePaths)),
+			"Packages from: "+strings.Join(config.Homebrew.BrewfilePaths, ", "))
+	} else {
+		items = append(items, "Packages from: "+brewfile.Path)
+		items = append(items, describeBrewfile(brewfile)...)
+	}
+	return newSetupStep(s, "Install Homebrew and packages from Brewfile", items, 15*time.Minute)
+}
+
+func (s homebrewStep) Plan(config *InstallConfig) []PlannedAction { return dryRunStep(s, config) }
+
+func (homebrewStep) Apply(run *stepRun) error { return installPackages(run) }
+
+func (homebrewStep) Verify(config *InstallConfig) []string {
+	manager, err := selectPackageManager(config)
+	if err != nil {
+		return nil
+	}
+	return []string{manager.Tool()}
+}
+
+func (homebrewStep) ReportHeading() string { return "## 🍺 Homebrew Packages" }
+
+func (homebrewStep) Report(config *InstallConfig) []string {
+	if name := packageManagerName(config); name != ManagerHomebrew {
+		manager, err := selectPackageManager(config)
+		if err != nil {
+			return []string{"- " + err.Error()}
+		}
+		packages, _ := manager.Packages(config)
+		return []string{fmt.Sprintf("- Installed %d package(s) with %s", len(packages), name)}
+	}
+	lines := []string{
+		fmt.Sprintf("- Installed from Brewfile (searched %d locations)", len(config.Homebrew.BrewfilePaths)),
+	}
+	if status := homebrewStatus; len(status.Installed)+len(status.Using)+len(status.Failed) > 0 {
+		lines = append(lines, fmt.Sprintf("- **Packages:** %d installed or upgraded, %d already up to date, %d failed",
+			len(status.Installed), len(status.Using), len(status.Failed)))
+		if len(status.Failed) > 0 {
+			lines = append(lines, fmt.Sprintf("- **Failed:** `%s`", strings.Join(status.Failed, "`, `")))
+		}
+	}
+	if config.Homebrew.LockFile != "" {
+		lines = append(lines, fmt.Sprintf("- **Lock file:** `%s` (%s)", config.Homebrew.LockFile, config.Homebrew.LockModeOrDefault()))
+		for _, mismatch := range homebrewStatus.LockMismatches {
+			lines = append(lines, fmt.Sprintf("  - differs: %s", mismatch))
+		}
+	}
+	lines = append(lines, "- Run `brew list` to see all installed packages")
+
+	// Show what merged Brewfiles added up to
+	if brewfile, err := loadBrewfile(config); err == nil && len(brewfile.Sources) > 1 {
+		lines = append(lines, "", "### 🍺 Merged Brewfile", "")
+		lines = append(lines, fmt.Sprintf("Layered from `%s`:", strings.Join(brewfile.Sources, "`, `")), "")
+		lines = append(lines, "```ruby")
+		lines = append(lines, strings.Split(strings.TrimSuffix(brewfile.Render(brewfile.Entries), "\n"), "\n")...)
+		lines = append(lines, "```")
+		for _, removal := range brewfile.Removals {
+			lines = append(lines, fmt.Sprintf("- removed `%s` (%s)", removal.Key(), removal.Source))
+		}
+	}
+	return lines
+}
+
+// terminalStep copies terminal application configuration
+type terminalStep struct{}
+
+func (terminalStep) ID() string    { return "terminal" }
+func (terminalStep) Title() string { return "Terminal Configuration" }
+
+func (terminalStep) Enabled(config *InstallConfig) bool { return config.Terminal.Install }
+
+func (terminalStep) Options(config *InstallConfig) StepOptions { return config.Terminal.StepOptions }
+
+// Fingerprint hashes the terminal section along with the template
+// variables and patterns, since config files may be rendered templates
+func (terminalStep) Fingerprint(config *InstallConfig) string {
+	return fingerprint(config.Terminal, config.Vars, config.Dotfiles.Templates)
+}
+
+func (s terminalStep) Describe(config *InstallConfig) SetupStep {
+	var terminalFiles []string
+	for src, mapping := range config.Terminal.ConfigFiles {
+		terminalFiles = append(terminalFiles, describeMapping(src, mapping, config.Terminal.LinkOptions))
+	}
+	return newSetupStep(s, "Configure terminal applications with Catppuccin theme", terminalFiles, 2*time.Minute)
+}
+
+func (s terminalStep) Plan(config *InstallConfig) []PlannedAction { return dryRunStep(s, config) }
+
+func (terminalStep) Apply(run *stepRun) error { return configureTerminal(run) }
+
+func (terminalStep) Verify(config *InstallConfig) []string { return nil }
+
+func (terminalStep) ReportHeading() string { return "## 💻 Terminal Configuration" }
+
+func (terminalStep) Report(config *InstallConfig) []string {
+	var lines []string
+	for src, mapping := range config.Terminal.ConfigFiles {
+		arrow := "→"
+		if mapping.LinkOptions.Resolve(config.Terminal.LinkOptions).Symlink() {
+			arrow = "🔗"
+		}
+		lines = append(lines, fmt.Sprintf("- `%s` %s `~/%s`", src, arrow, mapping.Dest))
+	}
+	if len(terminalStatus.MissingFiles) > 0 {
+		lines = append(lines, "", "### ❌ Missing Files")
+		for _, file := range terminalStatus.MissingFiles {
+			lines = append(lines, fmt.Sprintf("- `%s` (not found)", file))
+		}
+	}
+	return lines
+}
+
+// shellStep configures Zsh, the prompt theme and shell tools
+type shellStep struct{}
+
+func (shellStep) ID() string    { return "shell" }
+func (shellStep) Title() string { return "Shell & Prompt Setup" }
+
+func (shellStep) Enabled(config *InstallConfig) bool { return config.Shell.Install }
+
+// Options defaults to running after Homebrew, which installs the shell tools
+func (shellStep) Options(config *InstallConfig) StepOptions {
+	return config.Shell.StepOptions.withDefaultDependsOn("homebrew")
+}
+
+// Fingerprint hashes the shell section, whose shell files and theme may be
+// templates, with the variables and patterns they are rendered with
+func (shellStep) Fingerprint(config *InstallConfig) string {
+	return fingerprint(config.Shell, config.Vars, config.Dotfiles.Templates)
+}
+
+func (s shellStep) Describe(config *InstallConfig) SetupStep {
+	return newSetupStep(s, "Configure Zsh with Oh-My-Posh and productivity tools", []string{
+		fmt.Sprintf("Required tools: %s", strings.Join(config.Shell.RequiredTools, ", ")),
+		fmt.Sprintf("Shell files: %s", strings.Join(describeShellFiles(config), ", ")),
+		fmt.Sprintf("Theme file: %s", config.Shell.ThemeFile),
+		fmt.Sprintf("Init commands: %d configured", len(config.Shell.InitCommands)),
+	}, 3*time.Minute)
+}
+
+func (s shellStep) Plan(config *InstallConfig) []PlannedAction { return dryRunStep(s, config) }
+
+func (shellStep) Apply(run *stepRun) error { return configureShell(run) }
+
+func (shellStep) Verify(config *InstallConfig) []string { return config.Shell.RequiredTools }
+
+func (shellStep) ReportHeading() string { return "## 🐚 Shell Setup" }
+
+func (shellStep) Report(config *InstallConfig) []string {
+	return []string{
+		fmt.Sprintf("- **Required tools:** `%s`", strings.Join(config.Shell.RequiredTools, "`, `")),
+		fmt.Sprintf("- **Shell files:** `%s`", strings.Join(describeShellFiles(config), "`, `")),
+		fmt.Sprintf("- **Theme:** `%s`", config.Shell.ThemeFile),
+		fmt.Sprintf("- **Initialization commands:** %d executed", len(config.Shell.InitCommands)),
+	}
+}
+
+// describeShellFiles lists the shell files, marking those managed as a block
+func describeShellFiles(config *InstallConfig) []string {
+	var files []string
+	for _, file := range config.Shell.ShellFiles {
+		if file.ManageMode(config.Shell.Manage) == ManageBlock {
+			files = append(files, file.Path+" (managed block)")
+		} else {
+			files = append(files, file.Path)
+		}
+	}
+	return files
+}
+
+// devToolsStep sets up language toolchains and global tools
+type devToolsStep struct{}
+
+func (devToolsStep) ID() string    { return "devtools" }
+func (devToolsStep) Title() string { return "Development Tools" }
+
+func (devToolsStep) Enabled(config *InstallConfig) bool { return config.DevTools.Install }
+
+// Options defaults to running after Homebrew, which installs the toolchains
+func (devToolsStep) Options(config *InstallConfig) StepOptions {
+	return config.DevTools.StepOptions.withDefaultDependsOn("homebrew")
+}
+
+func (devToolsStep) Fingerprint(config *InstallConfig) string { return fingerprint(config.DevTools) }
+
+func (s devToolsStep) Describe(config *InstallConfig) SetupStep {
+	var devItems []string
+	for _, lang := range config.DevTools.Languages {
+		status := "disabled"
+		if lang.Enabled {
+			status = "enabled"
+		}
+		devItems = append(devItems, fmt.Sprintf("%s: %s (%d commands)", lang.Name, status, len(lang.Commands)))
+	}
+	devItems = append(devItems, fmt.Sprintf("Verify tools: %s", strings.Join(config.DevTools.VerifyTools, ", ")))
+	return newSetupStep(s, "Configure development environments and toolchains", devItems, 5*time.Minute)
+}
+
+func (s devToolsStep) Plan(config *InstallConfig) []PlannedAction { return dryRunStep(s, config) }
+
+func (devToolsStep) Apply(run *stepRun) error { return installDevTools(run) }
+
+func (devToolsStep) Verify(config *InstallConfig) []string { return config.DevTools.VerifyTools }
+
+func (devToolsStep) ReportHeading() string { return "## 🔧 Development Tools" }
+
+func (devToolsStep) Report(config *InstallConfig) []string {
+	var lines []string
+	for _, lang := range config.DevTools.Languages {
+		if lang.Enabled {
+			lines = append(lines, fmt.Sprintf("- **%s:** configured (%d commands executed)", lang.Name, len(lang.Commands)))
+		}
+	}
+	return lines
+}
+
+// dotfilesStep restores configuration files into the home directory
+type dotfilesStep struct{}
+
+func (dotfilesStep) ID() string    { return "dotfiles" }
+func (dotfilesStep) Title() string { return "Restore Dotfiles" }
+
+func (dotfilesStep) Enabled(config *InstallConfig) bool { return config.Dotfiles.Install }
+
+func (dotfilesStep) Options(config *InstallConfig) StepOptions { return config.Dotfiles.StepOptions }
+
+// Fingerprint hashes the dotfiles section, which holds the template
+// patterns itself, and the vars the templates are rendered with
+func (dotfilesStep) Fingerprint(config *InstallConfig) string {
+	return fingerprint(config.Dotfiles, config.Vars)
+}
+
+func (s dotfilesStep) Describe(config *InstallConfig) SetupStep {
+	var dotfileItems []string
+	for src, mapping := range config.Dotfiles.Mappings {
+		dotfileItems = append(dotfileItems, describeMapping(src, mapping, config.Dotfiles.LinkOptions))
+	}
+	return newSetupStep(s, "Copy configuration files to their destinations", dotfileItems, 1*time.Minute)
+}
+
+func (s dotfilesStep) Plan(config *InstallConfig) []PlannedAction { return dryRunStep(s, config) }
+
+func (dotfilesStep) Apply(run *stepRun) error { return restoreDotfiles(run) }
+
+func (dotfilesStep) Verify(config *InstallConfig) []string { return nil }
+
+func (dotfilesStep) ReportHeading() string { return "## 📁 Dotfiles" }
+
+func (dotfilesStep) Report(config *InstallConfig) []string {
+	var lines []string
+	if dotfilesStatus.IsCleanInstall {
+		lines = append(lines, "⚠️ **Clean Install** - Some dotfiles were not found", "")
+	}
+
+	if len(dotfilesStatus.CopiedFiles) > 0 {
+		lines = append(lines, "### ✅ Copied Files")
+		for _, file := range dotfilesStatus.CopiedFiles {
+			lines = append(lines, fmt.Sprintf("- `%s` → `~/%s`", file, config.Dotfiles.Mappings[file].Dest))
+		}
+		lines = append(lines, "")
+	}
+
+	if len(dotfilesStatus.LinkedFiles) > 0 {
+		lines = append(lines, "### 🔗 Linked Files")
+		for _, file := range dotfilesStatus.LinkedFiles {
+			lines = append(lines, fmt.Sprintf("- `~/%s` → `%s`", config.Dotfiles.Mappings[file].Dest, file))
+		}
+		lines = append(lines, "")
+	}
+
+	if len(dotfilesStatus.MissingFiles) > 0 {
+		lines = append(lines, "### ❌ Missing Files")
+		for _, file := range dotfilesStatus.MissingFiles {
+			lines = append(lines, fmt.Sprintf("- `%s` (not found)", file))
+		}
+		lines = append(lines, "")
+	}
+	return lines
+}
+
+// verifyStep checks that the tools of every enabled step are in PATH
+type verifyStep struct{}
+
+func (verifyStep) ID() string    { return "verify" }
+func (verifyStep) Title() string { return "Verify Installation" }
+
+// Enabled is always true: verification runs regardless of configuration
+func (verifyStep) Enabled(config *InstallConfig) bool { return true }
+
+// Options makes verification run after every other step has finished,
+// whether or not it completed, so it runs last
+func (verifyStep) Options(config *InstallConfig) StepOptions {
+	var others []string
+	for _, step := range stepRegistry {
+		if step.ID() != "verify" {
+			others = append(others, step.ID())
+		}
+	}
+	return StepOptions{After: others}
+}
+
+func (verifyStep) Fingerprint(config *InstallConfig) string {
+	return fingerprint(collectVerifyTools(config))
+}
+
+func (s verifyStep) Describe(config *InstallConfig) SetupStep {
+	return newSetupStep(s, "Test that all tools are properly installed and accessible", []string{
+		"Check tool availability in PATH",
+		"Validate configurations",
+		"Generate installation report",
+	}, 2*time.Minute)
+}
+
+func (s verifyStep) Plan(config *InstallConfig) []PlannedAction { return dryRunStep(s, config) }
+
+func (verifyStep) Apply(run *stepRun) error { return verifyInstallation(run) }
+
+func (verifyStep) Verify(config *InstallConfig) []string { return nil }
+
+// describeMapping renders a mapping as a step item, noting symlink mode
+func describeMapping(src string, mapping Mapping, defaults LinkOptions) string {
+	options := mapping.LinkOptions.Resolve(defaults)
+	if options.Symlink() {
+		return fmt.Sprintf("%s ⇠ %s (%s symlink)", src, mapping.Dest, options.Link)
+	}
+	return fmt.Sprintf("%s → %s", src, mapping.Dest)
+}
+
+// collectVerifyTools gathers the unique tools of every enabled step
+func collectVerifyTools(config *InstallConfig) []string {
+	toolSet := make(map[string]bool)
+	var uniqueTools []string
+	for _, step := range stepRegistry {
+		if !step.Enabled(config) {
+			continue
+		}
+		for _, tool := range step.Verify(config) {
+			if !toolSet[tool] {
+				toolSet[tool] = true
+				uniqueTools = append(uniqueTools, tool)
+			}
+		}
+	}
+	return uniqueTools
+}
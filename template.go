@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/template"
+)
+
+// templateSuffix marks a source file as a template; it is dropped from
+// the destination name
+const templateSuffix = ".tmpl"
+
+// isTemplate reports whether the source file is rendered before it is
+// written: it ends in .tmpl or matches a pattern in dotfiles.templates
+func isTemplate(src string, config *InstallConfig) bool {
+	if strings.HasSuffix(src, templateSuffix) {
+		return true
+	}
+	rel, err := filepath.Rel(currentDir, src)
+	if err != nil {
+		return false
+	}
+	for _, pattern := range config.Dotfiles.Templates {
+		if ok, _ := filepath.Match(pattern, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// templateDest returns where a source file is written: templates named
+// *.tmpl lose the suffix
+func templateDest(src, dest string) string {
+	if strings.HasSuffix(src, templateSuffix) {
+		return strings.TrimSuffix(dest, templateSuffix)
+	}
+	return dest
+}
+
+// templateVars collects the variables templates can use. Detected facts
+// come first, then the environment, then the config's vars section, each
+// overriding the one before.
+func templateVars(config *InstallConfig) map[string]string {
+	vars := map[string]string{
+		"Home": homeDir,
+		"HOME": homeDir,
+		"OS":   runtime.GOOS,
+		"Arch": runtime.GOARCH,
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		vars["Hostname"] = hostname
+		vars["ShortHostname"] = strings.SplitN(hostname, ".", 2)[0]
+	}
+	if current, err := user.Current(); err == nil {
+		vars["User"] = current.Username
+	} else {
+		vars["User"] = os.Getenv("USER")
+	}
+
+	for _, entry := range os.Environ() {
+		if name, value, ok := strings.Cut(entry, "="); ok {
+			vars[name] = value
+		}
+	}
+	for name, value := range config.Vars {
+		vars[name] = expandPath(value)
+	}
+	return vars
+}
+
+// renderTemplate renders the template file at src with the variables of
+// config. Unknown variables are an error rather than an empty string.
+func renderTemplate(src string, config *InstallConfig) ([]byte, error) {
+	text, err := os.ReadFile(src)
+	if err != nil {
+		return nil, err
+	}
+
+	vars := templateVars(config)
+	funcs := template.FuncMap{
+		"env": os.Getenv,
+		"default": func(fallback, value string) string {
+			if value == "" {
+				return fallback
+			}
+			return value
+		},
+	}
+	tmpl, err := template.New(filepath.Base(src)).Funcs(funcs).Option("missingkey=error").Parse(string(text))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template %s: %w", src, err)
+	}
+
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, vars); err != nil {
+		return nil, fmt.Errorf("failed to render template %s: %w", src, err)
+	}
+	return out.Bytes(), nil
+}
+
+// sourceContent returns what copying src would write: the rendered
+// template, or the file as it is
+func sourceContent(src string, config *InstallConfig) ([]byte, error) {
+	if isTemplate(src, config) {
+		return renderTemplate(src, config)
+	}
+	return os.ReadFile(src)
+}
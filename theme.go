@@ -97,6 +97,20 @@ var (
 				Foreground(lipgloss.Color(Red)).
 				Bold(true)
 
+	statusCancelledStyle = lipgloss.NewStyle().
+				Foreground(lipgloss.Color(Peach)).
+				Bold(true)
+
+	// Diff styles
+	diffAddStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color(Green))
+
+	diffRemoveStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color(Red))
+
+	diffHunkStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color(Blue))
+
 	// Footer style
 	footerStyle = lipgloss.NewStyle().
 			Foreground(lipgloss.Color(Subtext0)).